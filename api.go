@@ -16,9 +16,61 @@ type Iterator interface {
 	LoadNext(ctx context.Context, key *string, ob interface{}) error
 }
 
+// IndexValuer can be implemented by the data types to index computed values
+// that are not stored in the struct fields, like a full name derived from the
+// first and last name fields. Values are indexed as strings under the index
+// names used as keys in the map, so index names must not conflict with the
+// indexed field names. Empty values are not indexed.
+//
+// All index names must be declared by the IndexNames method, which is called
+// once on a zero value when the data type is registered, so that misspelled
+// index names in the queries can be rejected.
+//
+// Computed indexes can be queried with the FindByRange and FindByPrefix apis
+// using the index names in place of the field names.
+type IndexValuer interface {
+	IndexNames() []string
+	IndexValues() map[string][]string
+}
+
 type Finder interface {
 	// FindByIndex returns zero or more objects through the iterator. Indexed
 	// fields with non-zero value in the input object are used to select the
 	// index keys.
 	FindByIndex(ctx context.Context, partial interface{}, it Iterator) error
+
+	// CountByIndex and ExistsByIndex are similar to FindByIndex, but they only
+	// return the number of objects and whether an object exists, without
+	// unmarshaling the objects.
+	CountByIndex(ctx context.Context, partial interface{}) (int, error)
+	ExistsByIndex(ctx context.Context, partial interface{}) (bool, error)
+
+	// FindByIndexFields is similar to FindByIndex, but only the named fields
+	// are used to select the index keys, including the fields with zero values.
+	FindByIndexFields(ctx context.Context, partial interface{}, fields []string, it Iterator) error
+
+	// FindByRange returns zero or more objects with an indexed field's value in
+	// the closed interval [lo, hi] through the iterator. Input object only
+	// identifies the data type and nil lo or hi values leave the range
+	// unbounded on that side.
+	FindByRange(ctx context.Context, sample interface{}, field string, lo, hi interface{}, it Iterator) error
+
+	// FindByPrefix returns zero or more objects with an indexed string field's
+	// value beginning with the prefix through the iterator. Input object only
+	// identifies the data type.
+	FindByPrefix(ctx context.Context, sample interface{}, field, prefix string, it Iterator) error
+
+	// SearchText returns zero or more objects with all words of the query in a
+	// full-text field through the iterator. Input object only identifies the
+	// data type.
+	SearchText(ctx context.Context, sample interface{}, field, query string, it Iterator) error
+
+	// FindByQuery returns zero or more objects matching the query through the
+	// iterator. Input object only identifies the data type.
+	FindByQuery(ctx context.Context, sample interface{}, q *Query, it Iterator) error
+
+	// DistinctValues returns the distinct values of an indexed field with the
+	// number of objects for each value. Input object only identifies the data
+	// type.
+	DistinctValues(ctx context.Context, sample interface{}, field string, opts *DistinctOptions) ([]*DistinctValue, string, error)
 }
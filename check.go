@@ -0,0 +1,206 @@
+package kodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bvkgo/kodb/internal"
+)
+
+// ProblemKind identifies the type of an index consistency problem.
+type ProblemKind string
+
+const (
+	// DanglingIndexKey is an index key that refers to a missing object or to an
+	// object that doesn't list the index key. Repair deletes the index key.
+	DanglingIndexKey ProblemKind = "dangling-index-key"
+
+	// MissingIndexKey is an index key listed by an object that is not present
+	// in the index. Repair adds the index key.
+	MissingIndexKey ProblemKind = "missing-index-key"
+
+	// DanglingUniqueKey is an unique key that is not claimed by the index keys
+	// of it's object. Repair deletes the unique key.
+	DanglingUniqueKey ProblemKind = "dangling-unique-key"
+
+	// InvalidKey is a key or a value that cannot be parsed. Invalid keys are
+	// not repaired.
+	InvalidKey ProblemKind = "invalid-key"
+)
+
+// Problem describes an index consistency problem found by the Check api.
+type Problem struct {
+	// Kind identifies the type of the problem.
+	Kind ProblemKind
+
+	// Key holds the key with the problem, as stored in the key-value database.
+	Key string
+
+	// ObjectKey holds the user key of the object related to the problem, if
+	// any.
+	ObjectKey string
+
+	// Repaired is true if the problem is repaired.
+	Repaired bool
+}
+
+func (p *Problem) String() string {
+	return fmt.Sprintf("%s: key %q object %q repaired %t", p.Kind, p.Key, p.ObjectKey, p.Repaired)
+}
+
+// CheckOptions holds optional parameters for the Check api.
+type CheckOptions struct {
+	// BatchSize limits the number of keys scanned in a single transaction.
+	// DefaultBatchSize is used when it is zero.
+	BatchSize int
+
+	// Repair when true, fixes the problems found in the same transaction.
+	Repair bool
+}
+
+// Check cross-validates all index keys with the objects they refer to and all
+// objects with their index keys. Problems found are returned in the order of
+// their keys and are optionally repaired.
+//
+// Keys are scanned in separate transactions of bounded size, so problems
+// caused by concurrent updates can also be reported. See the indexing
+// guarantees in the README file.
+func (d *DB) Check(ctx context.Context, opts *CheckOptions) ([]*Problem, error) {
+	if opts == nil {
+		opts = new(CheckOptions)
+	}
+
+	var problems []*Problem
+	checkIndex := func(ctx context.Context, tx *Tx, keys, _ []string) error {
+		ps, err := tx.checkIndexKeys(ctx, keys, opts.Repair)
+		problems = append(problems, ps...)
+		return err
+	}
+	for _, keyspace := range []string{internal.IndexKeyspace, internal.TextKeyspace} {
+		r := internal.KeyspaceRange(keyspace)
+		if err := d.scanBatches(ctx, r, opts.BatchSize, checkIndex, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	checkObjects := func(ctx context.Context, tx *Tx, keys, values []string) error {
+		ps, err := tx.checkObjects(ctx, keys, values, opts.Repair)
+		problems = append(problems, ps...)
+		return err
+	}
+	r := internal.KeyspaceRange(internal.ObjectKeyspace)
+	if err := d.scanBatches(ctx, r, opts.BatchSize, checkObjects, nil); err != nil {
+		return nil, err
+	}
+
+	checkUnique := func(ctx context.Context, tx *Tx, keys, values []string) error {
+		ps, err := tx.checkUniqueKeys(ctx, keys, values, opts.Repair)
+		problems = append(problems, ps...)
+		return err
+	}
+	r = internal.KeyspaceRange(internal.UniqueKeyspace)
+	if err := d.scanBatches(ctx, r, opts.BatchSize, checkUnique, nil); err != nil {
+		return nil, err
+	}
+	return problems, nil
+}
+
+// checkIndexKeys verifies that index keys refer to objects that list them.
+func (t *Tx) checkIndexKeys(ctx context.Context, keys []string, repair bool) ([]*Problem, error) {
+	var problems []*Problem
+	for _, k := range keys {
+		ik, err := internal.ParseIndexKey(k)
+		if err != nil {
+			problems = append(problems, &Problem{Kind: InvalidKey, Key: k})
+			continue
+		}
+		okey, err := ik.GetObjectKey()
+		if err != nil {
+			problems = append(problems, &Problem{Kind: InvalidKey, Key: k})
+			continue
+		}
+		if _, err := t.getRef(ctx, okey, []internal.IndexKey{ik}); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return problems, err
+			}
+			p := &Problem{Kind: DanglingIndexKey, Key: k, ObjectKey: okey.UserKey()}
+			if repair {
+				if err := t.tx.Delete(ctx, k); err != nil {
+					return problems, err
+				}
+				p.Repaired = true
+			}
+			problems = append(problems, p)
+		}
+	}
+	return problems, nil
+}
+
+// checkObjects verifies that all index keys listed by the objects are present.
+func (t *Tx) checkObjects(ctx context.Context, keys, values []string, repair bool) ([]*Problem, error) {
+	var problems []*Problem
+	for i, k := range keys {
+		v, err := internal.ParseValue(values[i])
+		if err != nil || v.ObjectKey.String() != k {
+			problems = append(problems, &Problem{Kind: InvalidKey, Key: k})
+			continue
+		}
+		for _, ik := range v.IndexKeys {
+			if _, err := t.tx.Get(ctx, ik.String()); err == nil {
+				continue
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return problems, err
+			}
+			p := &Problem{Kind: MissingIndexKey, Key: ik.String(), ObjectKey: v.ObjectKey.UserKey()}
+			if repair {
+				if err := t.tx.Set(ctx, ik.String(), v.Projection); err != nil {
+					return problems, err
+				}
+				p.Repaired = true
+			}
+			problems = append(problems, p)
+		}
+	}
+	return problems, nil
+}
+
+// checkUniqueKeys verifies that unique keys are claimed by the index keys of
+// the objects they refer to.
+func (t *Tx) checkUniqueKeys(ctx context.Context, keys, values []string, repair bool) ([]*Problem, error) {
+	var problems []*Problem
+	for i, k := range keys {
+		okey, err := internal.ParseObjectKey(values[i])
+		if err != nil {
+			problems = append(problems, &Problem{Kind: InvalidKey, Key: k})
+			continue
+		}
+		claimed := false
+		if v, err := t.getRef(ctx, okey, nil); err == nil {
+			for _, ik := range v.IndexKeys {
+				if ik.IsTextKey() {
+					continue
+				}
+				if ukey, err := ik.UniqueKey(); err == nil && ukey == k {
+					claimed = true
+					break
+				}
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return problems, err
+		}
+		if claimed {
+			continue
+		}
+		p := &Problem{Kind: DanglingUniqueKey, Key: k, ObjectKey: okey.UserKey()}
+		if repair {
+			if err := t.tx.Delete(ctx, k); err != nil {
+				return problems, err
+			}
+			p.Repaired = true
+		}
+		problems = append(problems, p)
+	}
+	return problems, nil
+}
@@ -2,10 +2,11 @@ package kodb
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
 	"os"
+	"reflect"
 
 	"github.com/bvkgo/kodb/internal"
 	"github.com/bvkgo/kv"
@@ -22,13 +23,50 @@ type DB struct {
 type Tx struct {
 	db *DB
 	tx kv.Transaction
+
+	// readRepair when true, collects the stale index keys found through the
+	// index lookups, so that they can be deleted when the transaction is
+	// committed.
+	readRepair bool
+	staleKeys  []internal.IndexKey
 }
 
 type Iter struct {
-	tx   *Tx
-	next int
-	keys []internal.ObjectKey
-	refs [][]internal.IndexKey
+	tx *Tx
+
+	// stream produces the objects lazily as the iterator advances. cur holds
+	// the object at the stream that is not yet consumed.
+	stream objectStream
+	cur    *iterRef
+
+	// limit and after hold the limit and the position to resume from for the
+	// next query through the iterator. They are taken over by the query when
+	// it is started, so they are not applied to the later queries.
+	limit int
+	after string
+
+	// max when non-zero, is the maximum number of objects returned from the
+	// current query and count is the number of objects returned so far. last
+	// holds the position of the last returned object, or the resume position
+	// until an object is returned, and done is true when the query has no
+	// more objects.
+	max   int
+	count int
+	last  string
+	done  bool
+
+	// loaded holds the number of objects read from the database.
+	loaded int
+}
+
+// iterRef holds an object key at the iterator with the referring index keys
+// and the projected fields. Position of the reference in the stream is used
+// to resume the stream from the next object.
+type iterRef struct {
+	okey internal.ObjectKey
+	refs []internal.IndexKey
+	proj string
+	pos  string
 }
 
 // New creates a key-object database out of a key-value database.
@@ -51,9 +89,45 @@ func (d *DB) NewTx(ctx context.Context) (*Tx, error) {
 
 // Commit commits all changes made by the transaction.
 func (t *Tx) Commit(ctx context.Context) error {
+	if err := t.deleteStaleKeys(ctx); err != nil {
+		return err
+	}
 	return t.tx.Commit(ctx)
 }
 
+// SetReadRepair enables or disables the read-repair mode for the transaction.
+// In the read-repair mode, stale index keys found by the index lookups are
+// deleted when the transaction is committed, so that the index doesn't
+// accumulate stale index keys over time. Stale index keys are not deleted if
+// the transaction is rolled back.
+func (t *Tx) SetReadRepair(enable bool) {
+	t.readRepair = enable
+}
+
+// deleteStaleKeys deletes the stale index keys collected in the read-repair
+// mode. Index keys are validated again, because they could have been
+// restored by the transaction after they were found to be stale.
+func (t *Tx) deleteStaleKeys(ctx context.Context) error {
+	// Validation below must not collect the stale index keys again.
+	stale := t.staleKeys
+	t.staleKeys, t.readRepair = nil, false
+	for _, ik := range stale {
+		okey, err := ik.GetObjectKey()
+		if err != nil {
+			return err
+		}
+		if _, err := t.getRef(ctx, okey, []internal.IndexKey{ik}); err == nil {
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := t.tx.Delete(ctx, ik.String()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
 // Rollback drops all changes made by the transaction.
 func (t *Tx) Rollback(ctx context.Context) error {
 	return t.tx.Rollback(ctx)
@@ -66,15 +140,27 @@ func (t *Tx) Get(ctx context.Context, key string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	s, err := t.tx.Get(ctx, okey.String())
+	v, err := t.getValue(ctx, okey)
 	if err != nil {
 		return "", err
 	}
+	return v.Data, nil
+}
+
+// getValue reads and parses the value stored at an object key.
+func (t *Tx) getValue(ctx context.Context, okey internal.ObjectKey) (*internal.Value, error) {
+	s, err := t.tx.Get(ctx, okey.String())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("key %s: %w", okey.UserKey(), ErrNotFound)
+		}
+		return nil, err
+	}
 	v, err := internal.ParseValue(s)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("could not parse value at key %s: %w", okey.UserKey(), err)
 	}
-	return v.Data, nil
+	return v, nil
 }
 
 // Set updates the data stored at the given key to the value.
@@ -83,10 +169,13 @@ func (t *Tx) Set(ctx context.Context, key, value string) error {
 	if err != nil {
 		return err
 	}
-	v := internal.NewStringValue(okey, value)
+	v, err := internal.NewStringValue(okey, value).Encode()
+	if err != nil {
+		return err
+	}
 	// NOTE: We don't bother to erase index keys referring to previous value
 	// cause stale index key references are checked when dereferenced.
-	return t.tx.Set(ctx, okey.String(), v.String())
+	return t.tx.Set(ctx, okey.String(), v)
 }
 
 // Delete removes data or object stored at the given key.
@@ -95,11 +184,7 @@ func (t *Tx) Delete(ctx context.Context, key string) error {
 	if err != nil {
 		return err
 	}
-	s, err := t.tx.Get(ctx, okey.String())
-	if err != nil {
-		return err
-	}
-	v, err := internal.ParseValue(s)
+	v, err := t.getValue(ctx, okey)
 	if err != nil {
 		return err
 	}
@@ -107,10 +192,12 @@ func (t *Tx) Delete(ctx context.Context, key string) error {
 		return err
 	}
 	for _, k := range v.IndexKeys {
-		if err := t.tx.Delete(ctx, k.String()); err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				panic("index consistency bug")
-			}
+		// A missing index key is already in the desired state, so it is not an
+		// error. See the indexing guarantees in the README file.
+		if err := t.tx.Delete(ctx, k.String()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := t.deleteUnique(ctx, k); err != nil {
 			return err
 		}
 	}
@@ -128,13 +215,12 @@ func (t *Tx) Load(ctx context.Context, key string, ob interface{}) error {
 	if err != nil {
 		return err
 	}
-	s, err := t.tx.Get(ctx, okey.String())
+	v, err := t.getValue(ctx, okey)
 	if err != nil {
 		return err
 	}
-	v, err := internal.ParseValue(s)
-	if err != nil {
-		return err
+	if v.Type != datatype.Name() {
+		return fmt.Errorf("key %s holds a value of type %s, not %s: %w", key, v.Type, datatype.Name(), ErrTypeMismatch)
 	}
 	if err := datatype.Unmarshal(v.Data, ob); err != nil {
 		return err
@@ -161,7 +247,7 @@ func (t *Tx) Store(ctx context.Context, key string, ob interface{}) error {
 	if len(s) > 0 {
 		v, err := internal.ParseValue(s)
 		if err != nil {
-			return fmt.Errorf("could not find indexed keys for old instance: %w", err)
+			return fmt.Errorf("could not find index keys for old value at key %s (%v): %w", key, err, ErrIndexInconsistent)
 		}
 		old = v
 	}
@@ -175,21 +261,120 @@ func (t *Tx) Store(ctx context.Context, key string, ob interface{}) error {
 	// iterator. See the indexing guarantees in the README file.
 	deletions, additions := internal.DiffIndexKeys(old.IndexKeys, cur.IndexKeys)
 	for _, i := range additions {
-		if err := t.tx.Set(ctx, i.String(), ""); err != nil {
+		if err := t.checkUnique(ctx, datatype, okey, i); err != nil {
 			return err
 		}
-		log.Printf("adding %s with index key %s", key, i)
 	}
-	if err := t.tx.Set(ctx, okey.String(), cur.String()); err != nil {
+	// All index keys are updated when the projected fields are modified.
+	updates := additions
+	if cur.Projection != old.Projection {
+		updates = cur.IndexKeys
+	}
+	for _, i := range updates {
+		if err := t.tx.Set(ctx, i.String(), cur.Projection); err != nil {
+			return err
+		}
+	}
+	value, err := cur.Encode()
+	if err != nil {
+		return err
+	}
+	if err := t.tx.Set(ctx, okey.String(), value); err != nil {
 		return err
 	}
 	for _, d := range deletions {
-		if err := t.tx.Delete(ctx, d.String()); err != nil {
+		// A missing index key is already in the desired state, so it is not an
+		// error. See the indexing guarantees in the README file.
+		if err := t.tx.Delete(ctx, d.String()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := t.deleteUnique(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUnique verifies that no other object holds the same field value as the
+// index key if it belongs to an unique index field. Index keys with stale
+// object references are ignored, similar to the index lookups.
+//
+// Transactions adding the same value to an unique field also update a common
+// unique key for the value, so that conflicting transactions cannot be
+// committed simultaneously.
+func (t *Tx) checkUnique(ctx context.Context, datatype *internal.DataType, okey internal.ObjectKey, ik internal.IndexKey) error {
+	if ik.IsTextKey() {
+		return nil
+	}
+	field, err := ik.GetFieldName()
+	if err != nil {
+		return err
+	}
+	if !datatype.IsUniqueField(field) {
+		return nil
+	}
+	r, err := ik.IndexKeyRange()
+	if err != nil {
+		return err
+	}
+	refs, _, err := t.scanRange(ctx, r, 0)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		rk, err := internal.ParseIndexKey(ref)
+		if err != nil {
+			return fmt.Errorf("unexpected index key failure: %w", err)
+		}
+		rok, err := rk.GetObjectKey()
+		if err != nil {
+			return fmt.Errorf("index key with invalid object key: %w", err)
+		}
+		if rok == okey {
+			continue
+		}
+		if _, err := t.getRef(ctx, rok, []internal.IndexKey{rk}); err != nil {
 			if errors.Is(err, os.ErrNotExist) {
-				panic("index consistency bug")
+				continue
 			}
 			return err
 		}
+		return &UniqueError{Type: datatype.Name(), Field: field, Key: rok.UserKey()}
+	}
+	ukey, err := ik.UniqueKey()
+	if err != nil {
+		return err
+	}
+	return t.tx.Set(ctx, ukey, okey.String())
+}
+
+// deleteUnique removes the unique key for an index key if it is held by the
+// index key's object. Unique keys are checked without the data type, so that
+// objects of unregistered (eg: renamed) data types can also be deleted.
+func (t *Tx) deleteUnique(ctx context.Context, ik internal.IndexKey) error {
+	if ik.IsTextKey() {
+		return nil
+	}
+	okey, err := ik.GetObjectKey()
+	if err != nil {
+		return err
+	}
+	ukey, err := ik.UniqueKey()
+	if err != nil {
+		return err
+	}
+	holder, err := t.tx.Get(ctx, ukey)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if holder != okey.String() {
+		return nil
+	}
+	if err := t.tx.Delete(ctx, ukey); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
 	}
 	return nil
 }
@@ -197,6 +382,21 @@ func (t *Tx) Store(ctx context.Context, key string, ob interface{}) error {
 // FindByIndex scans the database index for objects with indexed field values
 // matching the input object.
 func (t *Tx) FindByIndex(ctx context.Context, part interface{}, iterator Iterator) error {
+	return t.findByIndex(ctx, part, nil, iterator)
+}
+
+// FindByIndexFields is similar to FindByIndex, but only the named fields of the
+// input object are used to select the index keys, including the fields with
+// zero values. Fields must be indexed with the zero option to find objects
+// with zero values.
+func (t *Tx) FindByIndexFields(ctx context.Context, part interface{}, fields []string, iterator Iterator) error {
+	if fields == nil {
+		fields = []string{}
+	}
+	return t.findByIndex(ctx, part, fields, iterator)
+}
+
+func (t *Tx) findByIndex(ctx context.Context, part interface{}, fields []string, iterator Iterator) error {
 	iter, ok := iterator.(*Iter)
 	if !ok {
 		return os.ErrInvalid
@@ -206,97 +406,378 @@ func (t *Tx) FindByIndex(ctx context.Context, part interface{}, iterator Iterato
 	if err != nil {
 		return err
 	}
-	ikMap, err := datatype.IndexKeyMap(part)
+	ranges, err := datatype.QueryRanges(part, fields)
 	if err != nil {
 		return err
 	}
+	return t.findByStream(ctx, ranges, false /* distinct */, iter)
+}
 
-	var refs []string
-	for _, ik := range ikMap {
-		r, err := ik.IndexKeyRange()
-		if err != nil {
-			return err
+// CountByIndex returns the number of objects FindByIndex api would return for
+// the input object. Index keys are validated against the objects, same as the
+// LoadNext method, but the objects are not unmarshaled.
+func (t *Tx) CountByIndex(ctx context.Context, part interface{}) (int, error) {
+	var it Iter
+	if err := t.findByIndex(ctx, part, nil, &it); err != nil {
+		return 0, err
+	}
+	return it.skipN(ctx, 0)
+}
+
+// ExistsByIndex returns true if FindByIndex api would return at least one
+// object for the input object. Like the CountByIndex api, objects are not
+// unmarshaled.
+func (t *Tx) ExistsByIndex(ctx context.Context, part interface{}) (bool, error) {
+	var it Iter
+	if err := t.findByIndex(ctx, part, nil, &it); err != nil {
+		return false, err
+	}
+	n, err := it.skipN(ctx, 1)
+	return n > 0, err
+}
+
+// SearchText scans the full-text index for objects with all words of the
+// query in the text field. Input object is only used to identify the data
+// type. Query words are matched after the same normalization as the field
+// values, so the search is case-insensitive.
+func (t *Tx) SearchText(ctx context.Context, sample interface{}, field, query string, iterator Iterator) error {
+	iter, ok := iterator.(*Iter)
+	if !ok {
+		return os.ErrInvalid
+	}
+
+	datatype, err := internal.GetDataType(sample)
+	if err != nil {
+		return err
+	}
+	ranges, err := datatype.TextQueryRanges(field, query)
+	if err != nil {
+		return err
+	}
+	return t.findByStream(ctx, ranges, false /* distinct */, iter)
+}
+
+// findByStream initializes the iterator to stream the objects referred by
+// the index keys in all of the ranges. Objects are returned in the ascending
+// order of the object keys, unless all ranges are partial composite values,
+// which are returned in the ascending order of their values.
+func (t *Tx) findByStream(ctx context.Context, ranges []internal.QueryRange, distinct bool, iter *Iter) error {
+	stream, err := t.newIndexStream(ctx, ranges, internal.IndexKey(iter.after), distinct)
+	if err != nil {
+		return err
+	}
+	iter.reset(t, stream)
+	return nil
+}
+
+// FindByRange scans the database index for objects with the indexed field
+// value in the closed interval [lo, hi]. Input object is only used to identify
+// the data type and a nil lo or hi value leaves the range unbounded on that
+// side. Numeric bounds outside the range of the field type are clamped to the
+// range. Objects are returned in the ascending order of the field values.
+func (t *Tx) FindByRange(ctx context.Context, sample interface{}, field string, lo, hi interface{}, iterator Iterator) error {
+	iter, ok := iterator.(*Iter)
+	if !ok {
+		return os.ErrInvalid
+	}
+
+	datatype, err := internal.GetDataType(sample)
+	if err != nil {
+		return err
+	}
+	r, err := datatype.IndexValueRange(field, lo, hi)
+	if err != nil {
+		return err
+	}
+	return t.findByIndexRange(ctx, r, datatype.IsMultiValued(field), iter)
+}
+
+// FindByPrefix scans the database index for objects with the indexed string
+// field value beginning with the prefix. Input object is only used to identify
+// the data type. Objects are returned in the ascending order of the field
+// values.
+func (t *Tx) FindByPrefix(ctx context.Context, sample interface{}, field, prefix string, iterator Iterator) error {
+	iter, ok := iterator.(*Iter)
+	if !ok {
+		return os.ErrInvalid
+	}
+
+	datatype, err := internal.GetDataType(sample)
+	if err != nil {
+		return err
+	}
+	r, err := datatype.IndexPrefixRange(field, prefix)
+	if err != nil {
+		return err
+	}
+	return t.findByIndexRange(ctx, r, datatype.IsMultiValued(field), iter)
+}
+
+// findByIndexRange initializes the iterator with objects referred by the
+// index keys in a range.
+func (t *Tx) findByIndexRange(ctx context.Context, r [2]string, multi bool, iter *Iter) error {
+	// Objects with multi-valued fields can be referred multiple times in the
+	// range, so they are returned only at their smallest value.
+	ranges := []internal.QueryRange{{Range: r}}
+	return t.findByStream(ctx, ranges, multi, iter)
+}
+
+// ascend returns an iterator over the key-value pairs in the [begin, end)
+// range. Returns nil iterator when the range is empty.
+func (t *Tx) ascend(ctx context.Context, r [2]string) (kv.Iterator, error) {
+	return t.scanIt(ctx, r, t.tx.Ascend)
+}
+
+// descend is similar to ascend, but returns the key-value pairs in the
+// descending order. Descend api excludes the range begin and includes the
+// range end instead, which doesn't matter for the index key ranges, because
+// their boundaries are never index keys.
+func (t *Tx) descend(ctx context.Context, r [2]string) (kv.Iterator, error) {
+	return t.scanIt(ctx, r, t.tx.Descend)
+}
+
+func (t *Tx) scanIt(ctx context.Context, r [2]string, scan func(context.Context, string, string, kv.Iterator) error) (kv.Iterator, error) {
+	// Ascend and Descend apis swap the range boundaries when begin is larger
+	// than end.
+	if r[0] >= r[1] {
+		return nil, nil
+	}
+	it, err := t.db.newIt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := scan(ctx, r[0], r[1], it); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
 		}
-		it, err := t.db.newIt(ctx)
+		return nil, nil
+	}
+	return it, nil
+}
+
+// scanRange returns up to n key-value pairs from the beginning of a range.
+// Zero n returns all key-value pairs in the range.
+func (t *Tx) scanRange(ctx context.Context, r [2]string, n int) ([]string, []string, error) {
+	it, err := t.ascend(ctx, r)
+	if err != nil || it == nil {
+		return nil, nil, err
+	}
+
+	var keys, values []string
+	for n == 0 || len(keys) < n {
+		k, v, err := it.GetNext(ctx)
 		if err != nil {
-			return err
-		}
-		if err := t.tx.Ascend(ctx, r[0], r[1], it); err != nil {
 			if !errors.Is(err, os.ErrNotExist) {
-				return err
+				return nil, nil, err
 			}
-			continue
+			break
 		}
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	return keys, values, nil
+}
 
-		for k, _, err := it.GetNext(ctx); true; k, _, err = it.GetNext(ctx) {
-			if err != nil {
-				if !errors.Is(err, os.ErrNotExist) {
-					return err
-				}
-				break
+// getRef returns the value for an object key referred by the index keys. There
+// can be index keys with stale object key references due to errors (when using
+// txes after a non-nil error). So, references are validated with the target
+// object metadata and os.ErrNotExist is returned for stale references. See the
+// indexing guarantees in README file.
+func (t *Tx) getRef(ctx context.Context, k internal.ObjectKey, refs []internal.IndexKey) (*internal.Value, error) {
+	s, err := t.tx.Get(ctx, k.String())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			t.addStaleKeys(refs...)
+		}
+		return nil, err
+	}
+	v, err := internal.ParseValue(s)
+	if err != nil {
+		return nil, err
+	}
+	if v.ObjectKey != k {
+		t.addStaleKeys(refs...)
+		return nil, os.ErrNotExist
+	}
+	if !v.HasAllIndexKeys(refs) {
+		for _, ref := range refs {
+			if !v.HasAllIndexKeys([]internal.IndexKey{ref}) {
+				t.addStaleKeys(ref)
 			}
-			refs = append(refs, k)
 		}
+		return nil, os.ErrNotExist
 	}
+	return v, nil
+}
 
-	// Dedup the object keys out of index keys.
-	refMap := map[internal.ObjectKey][]internal.IndexKey{}
-	for _, ref := range refs {
-		ik, err := internal.ParseIndexKey(ref)
-		if err != nil {
-			return fmt.Errorf("unexpected index key failure: %w", err)
-		}
-		ok, err := ik.GetObjectKey()
-		if err != nil {
-			return fmt.Errorf("index key with invalid object key: %w", err)
-		}
-		refMap[ok] = append(refMap[ok], ik)
+// addStaleKeys collects the stale index keys in the read-repair mode.
+func (t *Tx) addStaleKeys(iks ...internal.IndexKey) {
+	if t.readRepair {
+		t.staleKeys = append(t.staleKeys, iks...)
 	}
+}
 
-	oks := make([]internal.ObjectKey, 0, len(refMap))
-	iks := make([][]internal.IndexKey, 0, len(refMap))
-	for k, v := range refMap {
-		oks = append(oks, k)
-		iks = append(iks, v)
+// reset initializes the iterator with a new stream for a query.
+func (it *Iter) reset(t *Tx, stream objectStream) {
+	it.tx = t
+	it.stream = stream
+	it.cur = nil
+	it.max, it.limit = it.limit, 0
+	it.last, it.after = it.after, ""
+	it.count = 0
+	it.done = false
+	it.loaded = 0
+}
+
+// Explain returns the plan chosen for the last query through the iterator,
+// along with the number of keys examined and objects returned so far. Returns
+// nil if the iterator is not initialized with a query.
+func (it *Iter) Explain() *Plan {
+	if it.stream == nil {
+		return nil
 	}
+	p := it.stream.explain()
+	p.ObjectsLoaded = it.loaded
+	p.ObjectsReturned = it.count
+	return p
+}
+
+// SetLimit sets the maximum number of objects returned from the next query
+// through the iterator. Limit is cleared when the query is started, so it must
+// be set again before reusing the iterator for another query.
+func (it *Iter) SetLimit(n int) {
+	it.limit = n
+}
 
-	iter.tx = t
-	iter.next = 0
-	iter.keys = oks
-	iter.refs = iks
+// SetCursor sets the position to resume the next query through the iterator.
+// Cursor must be from an earlier iterator for the same query, which can be
+// from a different transaction. Empty cursor starts the query from it's first
+// object. Cursor is cleared when the query is started, so it is not applied
+// to the later queries through the iterator.
+func (it *Iter) SetCursor(cursor string) error {
+	if len(cursor) == 0 {
+		it.after = ""
+		return nil
+	}
+	s, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return fmt.Errorf("invalid cursor (%v): %w", err, os.ErrInvalid)
+	}
+	// Cursors hold an index key, or an object key for the queries without an
+	// usable index.
+	if _, err := internal.ParseIndexKey(string(s)); err != nil {
+		if _, err := internal.ParseObjectKey(string(s)); err != nil {
+			return fmt.Errorf("invalid cursor: %w", err)
+		}
+	}
+	it.after = string(s)
 	return nil
 }
 
-// GetNext returns the value at the iterator in the serialized form.
-func (it *Iter) GetNext(ctx context.Context) (string, string, error) {
-	for ; it.next < len(it.keys); it.next++ {
-		k := it.keys[it.next]
-		s, err := it.tx.tx.Get(ctx, k.String())
+// Cursor returns an opaque string that identifies the position of the
+// iterator, which can be used to resume the query from the next object with
+// the SetCursor method. Returns empty string when the query has no more
+// objects.
+func (it *Iter) Cursor() string {
+	if it.done {
+		return ""
+	}
+	if len(it.last) == 0 {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(it.last))
+}
+
+// peek returns the object reference at the iterator without advancing the
+// iterator. Returns os.ErrNotExist when there are no more objects or when the
+// limit is reached.
+func (it *Iter) peek(ctx context.Context) (*iterRef, error) {
+	if it.stream == nil || it.done {
+		return nil, os.ErrNotExist
+	}
+	if it.max > 0 && it.count >= it.max {
+		return nil, os.ErrNotExist
+	}
+	if it.cur == nil {
+		r, err := it.stream.next(ctx)
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) {
-				continue
+				it.done = true
 			}
-			return "", "", err
+			return nil, err
 		}
-		v, err := internal.ParseValue(s)
+		it.cur = r
+	}
+	return it.cur, nil
+}
+
+// skip advances the iterator past the object reference returned by peek.
+func (it *Iter) skip() {
+	it.cur = nil
+}
+
+// consume advances the iterator past the object reference returned by peek
+// after it is returned to the caller.
+func (it *Iter) consume(r *iterRef) {
+	it.cur = nil
+	it.count++
+	it.last = r.pos
+}
+
+// peekValue returns the next valid object at the iterator, skipping over the
+// stale references and the objects that do not match the stream filters.
+// Iterator is not advanced past the returned object.
+func (it *Iter) peekValue(ctx context.Context) (*iterRef, *internal.Value, error) {
+	for {
+		r, err := it.peek(ctx)
 		if err != nil {
-			return "", "", err
+			return nil, nil, err
 		}
-		// There can be index key with stale object key references due to errors
-		// (when using txes after a non-nil error). So, validate with the target
-		// object metadata.  See the indexing guarantees in README file.
-		if v.ObjectKey != k {
+		it.loaded++
+		v, err := it.tx.getRef(ctx, r.okey, r.refs)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				it.skip()
+				continue
+			}
+			return nil, nil, err
+		}
+		if ok, err := it.stream.match(v, r); err != nil {
+			return nil, nil, err
+		} else if !ok {
+			it.skip()
 			continue
 		}
-		if len(it.refs) > 0 {
-			if !v.HasAllIndexKeys(it.refs[it.next]) {
-				continue
+		return r, v, nil
+	}
+}
+
+// skipN advances the iterator over up to n objects without unmarshaling them
+// and returns the number of objects skipped. Zero n skips all objects.
+func (it *Iter) skipN(ctx context.Context, n int) (int, error) {
+	count := 0
+	for n == 0 || count < n {
+		r, _, err := it.peekValue(ctx)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				break
 			}
+			return count, err
 		}
-		it.next++
-		return k.UserKey(), v.Data, nil
+		it.consume(r)
+		count++
+	}
+	return count, nil
+}
+
+// GetNext returns the value at the iterator in the serialized form.
+func (it *Iter) GetNext(ctx context.Context) (string, string, error) {
+	r, v, err := it.peekValue(ctx)
+	if err != nil {
+		return "", "", err
 	}
-	return "", "", os.ErrNotExist
+	it.consume(r)
+	return r.okey.UserKey(), v.Data, nil
 }
 
 // LoadNext reads current value at the iterator and also advances the iterator
@@ -307,38 +788,58 @@ func (it *Iter) LoadNext(ctx context.Context, key *string, ob interface{}) error
 		return err
 	}
 
-	for ; it.next < len(it.keys); it.next++ {
-		k := it.keys[it.next]
-		s, err := it.tx.tx.Get(ctx, k.String())
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				continue
-			}
-			return err
-		}
-		v, err := internal.ParseValue(s)
-		if err != nil {
-			return err
-		}
-		// There can be index key with stale object key references due to errors
-		// (when using txes after a non-nil error). So, validate with the target
-		// object metadata.  See the indexing guarantees in README file.
-		if v.ObjectKey != k {
-			continue
-		}
-		if len(it.refs) > 0 {
-			if !v.HasAllIndexKeys(it.refs[it.next]) {
-				continue
-			}
-		}
-		if err := datatype.Unmarshal(v.Data, ob); err != nil {
-			return err
-		}
-		if key != nil {
-			*key = k.UserKey()
-		}
-		it.next++
-		return nil
+	r, v, err := it.peekValue(ctx)
+	if err != nil {
+		return err
+	}
+	if v.Type != datatype.Name() {
+		return fmt.Errorf("key %s holds a value of type %s, not %s: %w", r.okey.UserKey(), v.Type, datatype.Name(), ErrTypeMismatch)
+	}
+	if err := datatype.Unmarshal(v.Data, ob); err != nil {
+		return err
 	}
-	return os.ErrNotExist
+	if key != nil {
+		*key = r.okey.UserKey()
+	}
+	it.consume(r)
+	return nil
+}
+
+// ProjectNextUnchecked is similar to LoadNext, but only the projected fields
+// of the object are read from the index key values, without loading the
+// object. Other fields of the object are reset to their zero values. Objects
+// indexed before their data type had projected fields are loaded in full, and
+// so are the objects found through partial composite index values, which must
+// be checked against all of their index keys.
+//
+// Unlike LoadNext, index keys are not validated against the objects, so
+// stale index keys left behind by failed transactions can be returned, which
+// includes the index keys of the deleted objects. See the indexing guarantees
+// in the README file.
+func (it *Iter) ProjectNextUnchecked(ctx context.Context, key *string, ob interface{}) error {
+	datatype, err := internal.GetDataType(ob)
+	if err != nil {
+		return err
+	}
+	r, err := it.peek(ctx)
+	if err != nil {
+		return err
+	}
+	if len(r.proj) == 0 || it.stream.filtered() {
+		return it.LoadNext(ctx, key, ob)
+	}
+
+	ovalue := reflect.ValueOf(ob)
+	if ovalue.Kind() != reflect.Ptr || ovalue.IsNil() {
+		return fmt.Errorf("input object must be a non-nil pointer: %w", os.ErrInvalid)
+	}
+	ovalue.Elem().Set(reflect.Zero(ovalue.Elem().Type()))
+	if err := datatype.Unmarshal(r.proj, ob); err != nil {
+		return err
+	}
+	if key != nil {
+		*key = r.okey.UserKey()
+	}
+	it.consume(r)
+	return nil
 }
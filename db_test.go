@@ -3,9 +3,14 @@ package kodb
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
 	"os"
 	"path"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bvkgo/kodb/internal"
 	"github.com/bvkgo/kv"
@@ -133,3 +138,2435 @@ func TestIndexing(t *testing.T) {
 		}
 	}
 }
+
+func TestFindByRange(t *testing.T) {
+	ctx := context.Background()
+
+	type RangeType struct {
+		Name  string
+		Age   int    `kodb:"index"`
+		Level uint8  `kodb:"index"`
+		Code  string `kodb:"index"`
+	}
+
+	if err := internal.Register("TestFindByRange.RangeType", RangeType{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	users := []*RangeType{
+		{Name: "alex", Age: -5, Level: 1, Code: "a"},
+		{Name: "ben", Age: 9, Level: 2, Code: "a b"},
+		{Name: "carter", Age: 20, Level: 30, Code: "a/b"},
+		{Name: "dave", Age: 100, Level: 200, Code: "ab"},
+		{Name: "ethan", Age: 1000, Level: 255, Code: "b"},
+	}
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, u := range users {
+		if err := tx.Store(ctx, path.Join("/users", u.Name), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	findByRange := func(field string, lo, hi interface{}) []string {
+		var it Iter
+		if err := tx.FindByRange(ctx, RangeType{}, field, lo, hi, &it); err != nil {
+			t.Fatal(err)
+		}
+		var matched []string
+		var user RangeType
+		for err := it.LoadNext(ctx, nil /* key */, &user); err == nil; err = it.LoadNext(ctx, nil /* key */, &user) {
+			matched = append(matched, user.Name)
+		}
+		return matched
+	}
+
+	testcases := []struct {
+		field  string
+		lo, hi interface{}
+		want   []string
+	}{
+		{"Age", 9, 100, []string{"ben", "carter", "dave"}},
+		{"Age", -10, 10, []string{"alex", "ben"}},
+		{"Age", nil, 20, []string{"alex", "ben", "carter"}},
+		{"Age", 21, nil, []string{"dave", "ethan"}},
+		{"Age", nil, nil, []string{"alex", "ben", "carter", "dave", "ethan"}},
+		{"Age", 100, 9, nil},
+		{"Level", 2, 200, []string{"ben", "carter", "dave"}},
+		{"Level", uint8(255), nil, []string{"ethan"}},
+		// Boundaries outside the field type's range are clamped.
+		{"Level", -1, 10, []string{"alex", "ben"}},
+		{"Level", 100, 300, []string{"dave", "ethan"}},
+		{"Level", 256, nil, nil},
+		{"Level", nil, -1, nil},
+		{"Code", "a", "ab", []string{"alex", "ben", "carter", "dave"}},
+		{"Code", "a ", "a0", []string{"ben", "carter"}},
+		{"Code", "aa", nil, []string{"dave", "ethan"}},
+		// Empty strings are not indexed, so they sort before all values.
+		{"Code", nil, "", nil},
+		{"Code", "", "a", []string{"alex"}},
+		{"Code", "", nil, []string{"alex", "ben", "carter", "dave", "ethan"}},
+	}
+	for i, tc := range testcases {
+		got := findByRange(tc.field, tc.lo, tc.hi)
+		if len(got) != len(tc.want) {
+			t.Fatalf("testcase %d: want %v got %v", i, tc.want, got)
+		}
+		for j := range got {
+			if got[j] != tc.want[j] {
+				t.Fatalf("testcase %d: want %v got %v", i, tc.want, got)
+			}
+		}
+	}
+
+	var it Iter
+	if err := tx.FindByRange(ctx, RangeType{}, "Name", nil, nil, &it); err == nil {
+		t.Fatalf("range queries on non-indexed fields must fail")
+	}
+	if err := tx.FindByRange(ctx, RangeType{}, "Age", "10", nil, &it); err == nil {
+		t.Fatalf("range queries with mismatched types must fail")
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	ctx := context.Background()
+
+	type UniqueType struct {
+		Name  string
+		Email string `kodb:"index,unique"`
+	}
+
+	if err := internal.Register("TestUniqueIndex.UniqueType", UniqueType{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	{
+		t1, err := db.NewTx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := t1.Store(ctx, "/users/alex", &UniqueType{Name: "alex", Email: "alex@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		// Storing the same object again must not conflict with itself.
+		if err := t1.Store(ctx, "/users/alex", &UniqueType{Name: "Alex", Email: "alex@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		err = t1.Store(ctx, "/users/ben", &UniqueType{Name: "ben", Email: "alex@example.com"})
+		var uerr *UniqueError
+		if !errors.As(err, &uerr) || !errors.Is(err, os.ErrExist) {
+			t.Fatalf("want unique error got %v", err)
+		}
+		if uerr.Key != "/users/alex" || uerr.Field != "Email" {
+			t.Fatalf("unexpected unique error %v", uerr)
+		}
+		if err := t1.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Values can be reused after the objects are updated or deleted.
+	{
+		t2, err := db.NewTx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := t2.Store(ctx, "/users/alex", &UniqueType{Name: "alex", Email: "alex@example.org"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := t2.Store(ctx, "/users/ben", &UniqueType{Name: "ben", Email: "alex@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := t2.Delete(ctx, "/users/alex"); err != nil {
+			t.Fatal(err)
+		}
+		if err := t2.Store(ctx, "/users/carter", &UniqueType{Name: "carter", Email: "alex@example.org"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := t2.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Concurrent transactions cannot claim the same value.
+	{
+		t3, err := db.NewTx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t4, err := db.NewTx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := t3.Store(ctx, "/users/dave", &UniqueType{Name: "dave", Email: "dave@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := t4.Store(ctx, "/users/ethan", &UniqueType{Name: "ethan", Email: "dave@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := t3.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := t4.Commit(ctx); err == nil {
+			t.Fatalf("conflicting transaction must not be committed")
+		}
+	}
+
+	// Objects of unknown data types can be deleted along with their unique keys.
+	{
+		datatype, err := internal.NewDataType("TestUniqueIndex.Unknown", UniqueType{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		okey, err := internal.NewObjectKey("/users/frank")
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := internal.NewValue(okey, &UniqueType{Email: "frank@example.com"}, datatype)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := v.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ukey, err := v.IndexKeys[0].UniqueKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		kvtx := kvdb.NewTx()
+		if err := kvtx.Set(ctx, okey.String(), s); err != nil {
+			t.Fatal(err)
+		}
+		if err := kvtx.Set(ctx, v.IndexKeys[0].String(), ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := kvtx.Set(ctx, ukey, okey.String()); err != nil {
+			t.Fatal(err)
+		}
+		if err := kvtx.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		t5, err := db.NewTx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer t5.Rollback(ctx)
+		if err := t5.Delete(ctx, "/users/frank"); err != nil {
+			t.Fatalf("objects of unknown data types must be deleted: %v", err)
+		}
+		if _, err := t5.Get(ctx, "/users/frank"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("want os.ErrNotExist after delete, got %v", err)
+		}
+		if _, err := t5.tx.Get(ctx, ukey); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("unique key must be deleted with the object, got %v", err)
+		}
+	}
+}
+
+func TestCompositeIndex(t *testing.T) {
+	ctx := context.Background()
+
+	type Ticket struct {
+		Name   string `kodb:"index"`
+		Tenant string `kodb:"index=tenant_status,1"`
+		Status string `kodb:"index,index=tenant_status,2"`
+	}
+
+	if err := internal.Register("TestCompositeIndex.Ticket", Ticket{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tickets := []*Ticket{
+		{Name: "a", Tenant: "x", Status: "open"},
+		{Name: "b", Tenant: "x", Status: "closed"},
+		{Name: "c", Tenant: "y", Status: "open"},
+		{Name: "d", Tenant: "xy", Status: "open"},
+		{Name: "e", Tenant: "x", Status: "open"},
+	}
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, v := range tickets {
+		if err := tx.Store(ctx, path.Join("/tickets", v.Name), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	find := func(part *Ticket) []string {
+		var it Iter
+		if err := tx.FindByIndex(ctx, part, &it); err != nil {
+			t.Fatal(err)
+		}
+		var matched []string
+		var ticket Ticket
+		for err := it.LoadNext(ctx, nil /* key */, &ticket); err == nil; err = it.LoadNext(ctx, nil /* key */, &ticket) {
+			matched = append(matched, ticket.Name)
+		}
+		sort.Strings(matched)
+		return matched
+	}
+
+	testcases := []struct {
+		part *Ticket
+		want []string
+	}{
+		{&Ticket{Tenant: "x", Status: "open"}, []string{"a", "e"}},
+		{&Ticket{Tenant: "x"}, []string{"a", "b", "e"}},
+		{&Ticket{Status: "open"}, []string{"a", "c", "d", "e"}},
+		{&Ticket{Name: "a", Status: "open"}, []string{"a"}},
+		{&Ticket{Name: "b", Status: "open"}, nil},
+		{&Ticket{Name: "b", Tenant: "x", Status: "closed"}, []string{"b"}},
+	}
+	for i, tc := range testcases {
+		got := find(tc.part)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Fatalf("testcase %d: want %v got %v", i, tc.want, got)
+		}
+	}
+}
+
+func TestMultiValuedIndex(t *testing.T) {
+	ctx := context.Background()
+
+	type Article struct {
+		Name   string
+		Tags   []string        `kodb:"index"`
+		Labels map[string]bool `kodb:"index"`
+		Scores [2]int          `kodb:"index"`
+		Hash   [4]byte         `kodb:"index"`
+	}
+
+	if err := internal.Register("TestMultiValuedIndex.Article", Article{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	articles := []*Article{
+		{Name: "a", Tags: []string{"go", "db"}, Labels: map[string]bool{"new": true}, Scores: [2]int{1, 5}, Hash: [4]byte{4, 9, 9, 9}},
+		{Name: "b", Tags: []string{"go", "go"}, Labels: map[string]bool{"old": true}, Scores: [2]int{2, 3}, Hash: [4]byte{9, 4}},
+		{Name: "c", Tags: []string{"db"}, Labels: map[string]bool{"new": true, "old": true}, Scores: [2]int{4, 4}, Hash: [4]byte{4}},
+	}
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, v := range articles {
+		if err := tx.Store(ctx, path.Join("/articles", v.Name), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collect := func(it *Iter) string {
+		var matched []string
+		var article Article
+		for err := it.LoadNext(ctx, nil /* key */, &article); err == nil; err = it.LoadNext(ctx, nil /* key */, &article) {
+			matched = append(matched, article.Name)
+		}
+		sort.Strings(matched)
+		return strings.Join(matched, ",")
+	}
+	find := func(part *Article) string {
+		var it Iter
+		if err := tx.FindByIndex(ctx, part, &it); err != nil {
+			t.Fatal(err)
+		}
+		return collect(&it)
+	}
+
+	if got := find(&Article{Tags: []string{"go"}}); got != "a,b" {
+		t.Fatalf("want a,b got %s", got)
+	}
+	if got := find(&Article{Tags: []string{"go", "db"}}); got != "a" {
+		t.Fatalf("want a got %s", got)
+	}
+	if got := find(&Article{Labels: map[string]bool{"old": true}}); got != "b,c" {
+		t.Fatalf("want b,c got %s", got)
+	}
+	if got := find(&Article{Tags: []string{"db"}, Labels: map[string]bool{"new": true}}); got != "a,c" {
+		t.Fatalf("want a,c got %s", got)
+	}
+
+	// Byte arrays are indexed as single values.
+	if got := find(&Article{Hash: [4]byte{4, 9, 9, 9}}); got != "a" {
+		t.Fatalf("want a got %s", got)
+	}
+	if got := find(&Article{Hash: [4]byte{4}}); got != "c" {
+		t.Fatalf("want c got %s", got)
+	}
+
+	// Removing an element must remove the object from the index.
+	articles[0].Tags = []string{"db"}
+	if err := tx.Store(ctx, "/articles/a", articles[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := find(&Article{Tags: []string{"go"}}); got != "b" {
+		t.Fatalf("want b got %s", got)
+	}
+
+	var it Iter
+	if err := tx.FindByRange(ctx, Article{}, "Scores", 3, 4, &it); err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(&it); got != "b,c" {
+		t.Fatalf("want b,c got %s", got)
+	}
+}
+
+type NestedAddress struct {
+	City string `kodb:"index"`
+	Zip  int
+}
+
+type NestedBase struct {
+	Tenant string `kodb:"index"`
+}
+
+func TestNestedIndex(t *testing.T) {
+	ctx := context.Background()
+
+	type Person struct {
+		NestedBase
+
+		Name    string
+		Home    NestedAddress
+		Office  *NestedAddress
+		Manager *Person
+	}
+
+	if err := internal.Register("TestNestedIndex.Person", Person{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	people := []*Person{
+		{NestedBase{"x"}, "a", NestedAddress{City: "paris"}, &NestedAddress{City: "london"}, nil},
+		{NestedBase{"x"}, "b", NestedAddress{City: "london"}, nil, nil},
+		{NestedBase{"y"}, "c", NestedAddress{City: "paris"}, &NestedAddress{City: "paris"}, &Person{Name: "a"}},
+	}
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, v := range people {
+		if err := tx.Store(ctx, path.Join("/people", v.Name), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	find := func(part *Person) string {
+		var it Iter
+		if err := tx.FindByIndex(ctx, part, &it); err != nil {
+			t.Fatal(err)
+		}
+		var matched []string
+		var person Person
+		for err := it.LoadNext(ctx, nil /* key */, &person); err == nil; err = it.LoadNext(ctx, nil /* key */, &person) {
+			matched = append(matched, person.Name)
+		}
+		sort.Strings(matched)
+		return strings.Join(matched, ",")
+	}
+
+	if got := find(&Person{Home: NestedAddress{City: "paris"}}); got != "a,c" {
+		t.Fatalf("want a,c got %s", got)
+	}
+	if got := find(&Person{Office: &NestedAddress{City: "london"}}); got != "a" {
+		t.Fatalf("want a got %s", got)
+	}
+	if got := find(&Person{NestedBase: NestedBase{"x"}, Home: NestedAddress{City: "london"}}); got != "b" {
+		t.Fatalf("want b got %s", got)
+	}
+
+	var it Iter
+	if err := tx.FindByRange(ctx, Person{}, "Office.City", "paris", "paris", &it); err != nil {
+		t.Fatal(err)
+	}
+	if key, _, err := it.GetNext(ctx); err != nil || key != "/people/c" {
+		t.Fatalf("want /people/c got %q (%v)", key, err)
+	}
+	if err := tx.FindByRange(ctx, Person{}, "Tenant", "y", "y", &it); err != nil {
+		t.Fatal(err)
+	}
+	if key, _, err := it.GetNext(ctx); err != nil || key != "/people/c" {
+		t.Fatalf("want /people/c got %q (%v)", key, err)
+	}
+}
+
+func TestZeroIndex(t *testing.T) {
+	ctx := context.Background()
+
+	type Task struct {
+		Name     string
+		Owner    string `kodb:"index,zero"`
+		Priority int    `kodb:"index"`
+		Done     bool   `kodb:"index,zero"`
+	}
+
+	if err := internal.Register("TestZeroIndex.Task", Task{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tasks := []*Task{
+		{Name: "a", Owner: "", Priority: 1},
+		{Name: "b", Owner: "alex", Priority: 0},
+		{Name: "c", Owner: "", Priority: 0, Done: true},
+		{Name: "d", Owner: "ben", Priority: 1, Done: true},
+	}
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, v := range tasks {
+		if err := tx.Store(ctx, path.Join("/tasks", v.Name), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	find := func(part *Task, fields []string) string {
+		var it Iter
+		if fields == nil {
+			if err := tx.FindByIndex(ctx, part, &it); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := tx.FindByIndexFields(ctx, part, fields, &it); err != nil {
+			t.Fatal(err)
+		}
+		var matched []string
+		var task Task
+		for err := it.LoadNext(ctx, nil /* key */, &task); err == nil; err = it.LoadNext(ctx, nil /* key */, &task) {
+			matched = append(matched, task.Name)
+		}
+		sort.Strings(matched)
+		return strings.Join(matched, ",")
+	}
+
+	if got := find(&Task{Priority: 1}, nil); got != "a,d" {
+		t.Fatalf("want a,d got %s", got)
+	}
+	if got := find(&Task{}, []string{"Owner"}); got != "a,c" {
+		t.Fatalf("want a,c got %s", got)
+	}
+	if got := find(&Task{Priority: 1}, []string{"Owner", "Priority"}); got != "a" {
+		t.Fatalf("want a got %s", got)
+	}
+	if got := find(&Task{Owner: "alex"}, []string{"Owner", "Done"}); got != "b" {
+		t.Fatalf("want b got %s", got)
+	}
+	if got := find(&Task{}, []string{"Done"}); got != "a,b" {
+		t.Fatalf("want a,b got %s", got)
+	}
+
+	var it Iter
+	if err := tx.FindByIndexFields(ctx, &Task{}, []string{"Priority"}, &it); err == nil {
+		t.Fatalf("zero values must not be found without the zero option")
+	}
+	if err := tx.FindByIndexFields(ctx, &Task{}, []string{"Name"}, &it); err == nil {
+		t.Fatalf("non-indexed fields cannot be used to find objects")
+	}
+}
+
+func TestFindByPrefix(t *testing.T) {
+	ctx := context.Background()
+
+	type Product struct {
+		SKU   string   `kodb:"index"`
+		Tags  []string `kodb:"index"`
+		Price int      `kodb:"index"`
+	}
+
+	if err := internal.Register("TestFindByPrefix.Product", Product{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	products := []*Product{
+		{SKU: "AB", Tags: []string{"x/y", "x"}},
+		{SKU: "AB-100", Tags: []string{"xy"}},
+		{SKU: "AB-200"},
+		{SKU: "AB/300", Tags: []string{"x/z"}},
+		{SKU: "ABC"},
+		{SKU: "AC"},
+	}
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for i, v := range products {
+		if err := tx.Store(ctx, fmt.Sprintf("/products/%d", i), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	find := func(field, prefix string) string {
+		var it Iter
+		if err := tx.FindByPrefix(ctx, Product{}, field, prefix, &it); err != nil {
+			t.Fatal(err)
+		}
+		var matched []string
+		var product Product
+		for err := it.LoadNext(ctx, nil /* key */, &product); err == nil; err = it.LoadNext(ctx, nil /* key */, &product) {
+			matched = append(matched, product.SKU)
+		}
+		return strings.Join(matched, ",")
+	}
+
+	testcases := []struct {
+		field, prefix, want string
+	}{
+		{"SKU", "AB-", "AB-100,AB-200"},
+		{"SKU", "AB", "AB,AB-100,AB-200,AB/300,ABC"},
+		{"SKU", "AB/", "AB/300"},
+		{"SKU", "A", "AB,AB-100,AB-200,AB/300,ABC,AC"},
+		{"SKU", "ABC", "ABC"},
+		{"SKU", "B", ""},
+		{"Tags", "x/", "AB,AB/300"},
+		{"Tags", "x", "AB,AB/300,AB-100"},
+	}
+	for i, tc := range testcases {
+		if got := find(tc.field, tc.prefix); got != tc.want {
+			t.Fatalf("testcase %d: want %s got %s", i, tc.want, got)
+		}
+	}
+
+	var it Iter
+	if err := tx.FindByPrefix(ctx, Product{}, "Price", "1", &it); err == nil {
+		t.Fatalf("prefix queries on non-string fields must fail")
+	}
+}
+
+func TestNormalizedIndex(t *testing.T) {
+	ctx := context.Background()
+
+	type Account struct {
+		Name  string `kodb:"index,trim,nfc"`
+		Email string `kodb:"index,unique,trim,fold"`
+	}
+
+	if err := internal.Register("TestNormalizedIndex.Account", Account{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Name is in the NFD form with a combining acute accent.
+	if err := tx.Store(ctx, "/accounts/1", &Account{Name: " Jose\u0301", Email: "Alice@Example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Store(ctx, "/accounts/2", &Account{Email: " alice@example.COM "}); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("case-folded emails must conflict, got %v", err)
+	}
+
+	findKey := func(part *Account) string {
+		var it Iter
+		if err := tx.FindByIndex(ctx, part, &it); err != nil {
+			t.Fatal(err)
+		}
+		key, _, err := it.GetNext(ctx)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatal(err)
+		}
+		return key
+	}
+	if key := findKey(&Account{Email: "ALICE@example.com"}); key != "/accounts/1" {
+		t.Fatalf("want /accounts/1 got %q", key)
+	}
+	if key := findKey(&Account{Name: "Jos\u00e9"}); key != "/accounts/1" {
+		t.Fatalf("want /accounts/1 got %q", key)
+	}
+	if key := findKey(&Account{Name: "jos\u00e9"}); key != "" {
+		t.Fatalf("names must not be case folded")
+	}
+
+	var it Iter
+	if err := tx.FindByPrefix(ctx, Account{}, "Email", " ALICE@", &it); err != nil {
+		t.Fatal(err)
+	}
+	if key, _, err := it.GetNext(ctx); err != nil || key != "/accounts/1" {
+		t.Fatalf("want /accounts/1 got %q (%v)", key, err)
+	}
+}
+
+func TestSearchText(t *testing.T) {
+	ctx := context.Background()
+
+	type Post struct {
+		Title string `kodb:"index,text"`
+		Body  string `kodb:"text,stop"`
+	}
+
+	if err := internal.Register("TestSearchText.Post", Post{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	posts := map[string]*Post{
+		"/posts/1": {Title: "Hello, World", Body: "The quick brown fox jumps over the lazy dog."},
+		"/posts/2": {Title: "Hello again", Body: "A quick brown-dog story."},
+		"/posts/3": {Title: "World news", Body: "Nothing QUICK to see here; 42 things."},
+	}
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for k, v := range posts {
+		if err := tx.Store(ctx, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(field, query string) string {
+		var it Iter
+		if err := tx.SearchText(ctx, Post{}, field, query, &it); err != nil {
+			t.Fatal(err)
+		}
+		var matched []string
+		var key string
+		var post Post
+		for err := it.LoadNext(ctx, &key, &post); err == nil; err = it.LoadNext(ctx, &key, &post) {
+			matched = append(matched, key)
+		}
+		sort.Strings(matched)
+		return strings.Join(matched, ",")
+	}
+
+	testcases := []struct {
+		field, query, want string
+	}{
+		{"Title", "hello", "/posts/1,/posts/2"},
+		{"Title", "WORLD", "/posts/1,/posts/3"},
+		{"Title", "hello world", "/posts/1"},
+		{"Title", "hello news", ""},
+		{"Body", "quick", "/posts/1,/posts/2,/posts/3"},
+		{"Body", "brown dog", "/posts/1,/posts/2"},
+		{"Body", "the lazy dog", "/posts/1"},
+		{"Body", "42", "/posts/3"},
+		{"Body", "fox-jumps", "/posts/1"},
+	}
+	for i, tc := range testcases {
+		if got := search(tc.field, tc.query); got != tc.want {
+			t.Fatalf("testcase %d: want %q got %q", i, tc.want, got)
+		}
+	}
+
+	// Updated and deleted objects must not be found with their old words.
+	if err := tx.Store(ctx, "/posts/1", &Post{Title: "Goodbye", Body: "The end."}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete(ctx, "/posts/3"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := search("Title", "hello"), "/posts/2"; got != want {
+		t.Fatalf("want %q got %q", want, got)
+	}
+	if got, want := search("Body", "quick"), "/posts/2"; got != want {
+		t.Fatalf("want %q got %q", want, got)
+	}
+	if got, want := search("Title", "goodbye"), "/posts/1"; got != want {
+		t.Fatalf("want %q got %q", want, got)
+	}
+
+	// Text fields do not conflict with the regular index on the same field.
+	var it Iter
+	if err := tx.FindByIndex(ctx, &Post{Title: "Goodbye"}, &it); err != nil {
+		t.Fatal(err)
+	}
+	if key, _, err := it.GetNext(ctx); err != nil || key != "/posts/1" {
+		t.Fatalf("want /posts/1 got %q (%v)", key, err)
+	}
+
+	if err := tx.SearchText(ctx, Post{}, "Body", "the", &it); err == nil {
+		t.Fatalf("queries with only stop words must fail")
+	}
+	if err := tx.SearchText(ctx, Post{}, "Missing", "x", &it); err == nil {
+		t.Fatalf("queries on non-text fields must fail")
+	}
+}
+
+type ComputedPerson struct {
+	First string
+	Last  string
+	Phone string `kodb:"index"`
+	Born  int
+}
+
+func (p *ComputedPerson) IndexNames() []string {
+	return []string{"FullName", "PhoneDigits", "Decade"}
+}
+
+func (p *ComputedPerson) IndexValues() map[string][]string {
+	digits := strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, p.Phone)
+	return map[string][]string{
+		"FullName":    {strings.TrimSpace(p.First + " " + p.Last)},
+		"PhoneDigits": {digits},
+		"Decade":      {fmt.Sprintf("%d0s", p.Born/10)},
+	}
+}
+
+func TestComputedIndex(t *testing.T) {
+	ctx := context.Background()
+
+	var _ IndexValuer = &ComputedPerson{}
+	if err := internal.Register("TestComputedIndex.ComputedPerson", ComputedPerson{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+	if err := internal.Register("TestComputedIndex.UndeclaredPerson", UndeclaredPerson{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	people := map[string]*ComputedPerson{
+		"/people/1": {First: "Ada", Last: "Lovelace", Phone: "(555) 010-1815", Born: 1815},
+		"/people/2": {First: "Alan", Last: "Turing", Phone: "555-010-1912", Born: 1912},
+		"/people/3": {First: "Grace", Last: "Hopper", Born: 1906},
+	}
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for k, v := range people {
+		if err := tx.Store(ctx, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collect := func(it *Iter) string {
+		var matched []string
+		var key string
+		var person ComputedPerson
+		for err := it.LoadNext(ctx, &key, &person); err == nil; err = it.LoadNext(ctx, &key, &person) {
+			matched = append(matched, key)
+		}
+		return strings.Join(matched, ",")
+	}
+
+	var it Iter
+	if err := tx.FindByRange(ctx, ComputedPerson{}, "FullName", "Alan Turing", "Alan Turing", &it); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := collect(&it), "/people/2"; got != want {
+		t.Fatalf("want %q got %q", want, got)
+	}
+	if err := tx.FindByRange(ctx, ComputedPerson{}, "PhoneDigits", "5550101815", "5550101815", &it); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := collect(&it), "/people/1"; got != want {
+		t.Fatalf("want %q got %q", want, got)
+	}
+	if err := tx.FindByPrefix(ctx, ComputedPerson{}, "Decade", "19", &it); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := collect(&it), "/people/3,/people/2"; got != want {
+		t.Fatalf("want %q got %q", want, got)
+	}
+	// Objects with empty computed values are not indexed.
+	if err := tx.FindByPrefix(ctx, ComputedPerson{}, "PhoneDigits", "", &it); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := collect(&it), "/people/1,/people/2"; got != want {
+		t.Fatalf("want %q got %q", want, got)
+	}
+
+	// Computed index keys are updated along with the object.
+	if err := tx.Store(ctx, "/people/2", &ComputedPerson{First: "Alan", Last: "M. Turing", Born: 1912}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.FindByPrefix(ctx, ComputedPerson{}, "FullName", "Alan", &it); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.LoadNext(ctx, nil /* key */, &ComputedPerson{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.LoadNext(ctx, nil /* key */, &ComputedPerson{}); err == nil {
+		t.Fatalf("stale computed index keys must not be found")
+	}
+	if err := tx.Delete(ctx, "/people/3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.FindByPrefix(ctx, ComputedPerson{}, "Decade", "190", &it); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := collect(&it), ""; got != want {
+		t.Fatalf("want %q got %q", want, got)
+	}
+
+	// Undeclared index names are not assumed to be computed indexes.
+	if err := tx.FindByPrefix(ctx, ComputedPerson{}, "FullNmae", "Alan", &it); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("want os.ErrInvalid for an undeclared index name, got %v", err)
+	}
+	if err := tx.FindByQuery(ctx, ComputedPerson{}, Where("Decade2").Eq("1910s"), &it); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("want os.ErrInvalid for an undeclared index name, got %v", err)
+	}
+	if err := tx.Store(ctx, "/people/4", &UndeclaredPerson{Name: "x"}); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("want os.ErrInvalid for an undeclared computed index, got %v", err)
+	}
+	if err := internal.Register("TestComputedIndex.UnnamedPerson", UnnamedPerson{}); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("want os.ErrInvalid for computed indexes without declared names, got %v", err)
+	}
+}
+
+type UndeclaredPerson struct {
+	Name string
+}
+
+func (p *UndeclaredPerson) IndexNames() []string {
+	return []string{"Upper"}
+}
+
+func (p *UndeclaredPerson) IndexValues() map[string][]string {
+	return map[string][]string{"Lower": {strings.ToLower(p.Name)}}
+}
+
+type UnnamedPerson struct {
+	Name string
+}
+
+func (p *UnnamedPerson) IndexValues() map[string][]string {
+	return map[string][]string{"Lower": {strings.ToLower(p.Name)}}
+}
+
+func TestTimeFloatPointerIndex(t *testing.T) {
+	ctx := context.Background()
+
+	type Event struct {
+		Name     string
+		Created  time.Time `kodb:"index"`
+		Score    float64   `kodb:"index"`
+		Priority *int      `kodb:"index"`
+		Owner    *string   `kodb:"index"`
+	}
+
+	if err := internal.Register("TestTimeFloatPointerIndex.Event", Event{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	zero, one := 0, 1
+	alice := "alice"
+	base := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	est := time.FixedZone("EST", -5*3600)
+	events := []*Event{
+		{Name: "a", Created: base, Score: -2.5, Priority: &one, Owner: &alice},
+		{Name: "b", Created: base.Add(time.Nanosecond), Score: 0, Priority: &zero},
+		{Name: "c", Created: base.Add(-time.Hour).In(est), Score: 10},
+		{Name: "d", Score: 1.25},
+	}
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, v := range events {
+		if err := tx.Store(ctx, "/events/"+v.Name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collect := func(it *Iter) string {
+		var matched []string
+		var event Event
+		for err := it.LoadNext(ctx, nil /* key */, &event); err == nil; err = it.LoadNext(ctx, nil /* key */, &event) {
+			matched = append(matched, event.Name)
+		}
+		return strings.Join(matched, ",")
+	}
+
+	testcases := []struct {
+		field  string
+		lo, hi interface{}
+		want   string
+	}{
+		{"Created", base, nil, "a,b"},
+		{"Created", nil, base, "c,a"},
+		{"Created", base.Add(time.Nanosecond).In(est), nil, "b"},
+		// Zero values are not indexed.
+		{"Score", nil, nil, "a,d,c"},
+		{"Score", -3, 2, "a,d"},
+		{"Score", 1.25, 1.25, "d"},
+		{"Priority", nil, nil, "b,a"},
+		{"Priority", 0, 0, "b"},
+		{"Owner", "alice", "alice", "a"},
+	}
+	for i, tc := range testcases {
+		var it Iter
+		if err := tx.FindByRange(ctx, Event{}, tc.field, tc.lo, tc.hi, &it); err != nil {
+			t.Fatal(err)
+		}
+		if got := collect(&it); got != tc.want {
+			t.Fatalf("testcase %d: want %q got %q", i, tc.want, got)
+		}
+	}
+
+	// Non-nil pointers to zero values are indexed and matched.
+	var it Iter
+	if err := tx.FindByIndex(ctx, &Event{Priority: &zero}, &it); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := collect(&it), "b"; got != want {
+		t.Fatalf("want %q got %q", want, got)
+	}
+}
+
+func TestCoveringIndex(t *testing.T) {
+	ctx := context.Background()
+
+	type Ticket struct {
+		Title   string `kodb:"project"`
+		Status  string `kodb:"index,project"`
+		Owner   string `kodb:"index"`
+		Details string
+	}
+
+	if err := internal.Register("TestCoveringIndex.Ticket", Ticket{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	tickets := map[string]*Ticket{
+		"/tickets/1": {Title: "crash", Status: "open", Owner: "alice", Details: "stack trace"},
+		"/tickets/2": {Title: "typo", Status: "closed", Owner: "bob", Details: "in docs"},
+	}
+	for k, v := range tickets {
+		if err := tx.Store(ctx, k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	project := func(part *Ticket) map[string]Ticket {
+		var it Iter
+		if err := tx.FindByIndex(ctx, part, &it); err != nil {
+			t.Fatal(err)
+		}
+		result := make(map[string]Ticket)
+		var key string
+		ticket := Ticket{Details: "must be reset"}
+		for err := it.ProjectNextUnchecked(ctx, &key, &ticket); err == nil; err = it.ProjectNextUnchecked(ctx, &key, &ticket) {
+			result[key] = ticket
+		}
+		return result
+	}
+
+	got := project(&Ticket{Owner: "alice"})
+	if want := (Ticket{Title: "crash", Status: "open"}); len(got) != 1 || got["/tickets/1"] != want {
+		t.Fatalf("want %v got %v", want, got)
+	}
+
+	// Index keys that are not modified must also reflect the updated
+	// projected fields.
+	if err := tx.Store(ctx, "/tickets/1", &Ticket{Title: "crash on start", Status: "open", Owner: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	got = project(&Ticket{Status: "open"})
+	if want := (Ticket{Title: "crash on start", Status: "open"}); len(got) != 1 || got["/tickets/1"] != want {
+		t.Fatalf("want %v got %v", want, got)
+	}
+	got = project(&Ticket{Owner: "bob", Status: "closed"})
+	if want := (Ticket{Title: "typo", Status: "closed"}); len(got) != 1 || got["/tickets/2"] != want {
+		t.Fatalf("want %v got %v", want, got)
+	}
+
+	// Index keys are not validated, so index keys of a deleted object are
+	// still returned.
+	if err := tx.tx.Delete(ctx, "/ob/tickets/2"); err != nil {
+		t.Fatal(err)
+	}
+	got = project(&Ticket{Owner: "bob"})
+	if want := (Ticket{Title: "typo", Status: "closed"}); len(got) != 1 || got["/tickets/2"] != want {
+		t.Fatalf("want %v got %v", want, got)
+	}
+
+	var it Iter
+	if err := tx.FindByIndex(ctx, &Ticket{Owner: "alice"}, &it); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.ProjectNextUnchecked(ctx, nil /* key */, Ticket{}); err == nil {
+		t.Fatalf("non-pointer objects must be rejected")
+	}
+}
+
+func TestReindex(t *testing.T) {
+	ctx := context.Background()
+
+	type Account struct {
+		Name  string `kodb:"index"`
+		Email string `kodb:"index,unique"`
+	}
+
+	if err := internal.Register("TestReindex.Account", Account{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+	datatype, err := internal.GetDataType(Account{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	// Objects stored before the fields were indexed have no index keys, but
+	// they could have index keys for the fields that are no longer indexed.
+	kvtx := kvdb.NewTx()
+	for i := 0; i < 10; i++ {
+		okey, err := internal.NewObjectKey(fmt.Sprintf("/accounts/%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := internal.NewValue(okey, &Account{Name: "user", Email: fmt.Sprintf("%d@x", i)}, datatype)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old, err := internal.NewIndexKey(okey, datatype.Name(), "Old", "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.IndexKeys = []internal.IndexKey{old}
+		if err := kvtx.Set(ctx, old.String(), ""); err != nil {
+			t.Fatal(err)
+		}
+		s, err := v.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := kvtx.Set(ctx, okey.String(), s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other, err := internal.NewStringValue("/ob/other", "x").Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kvtx.Set(ctx, "/ob/other", other); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvtx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func() int {
+		tx, err := db.NewTx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback(ctx)
+
+		var it Iter
+		if err := tx.FindByIndex(ctx, &Account{Name: "user"}, &it); err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for err := it.LoadNext(ctx, nil /* key */, &Account{}); err == nil; err = it.LoadNext(ctx, nil /* key */, &Account{}) {
+			n++
+		}
+		return n
+	}
+	if n := count(); n != 0 {
+		t.Fatalf("want no indexed objects got %d", n)
+	}
+
+	// Interrupt the reindex after the first batch and resume from the
+	// checkpoint.
+	errStop := errors.New("stop")
+	var checkpoint string
+	opts := &ReindexOptions{
+		BatchSize: 3,
+		Checkpoint: func(ctx context.Context, key string) error {
+			checkpoint = key
+			return errStop
+		},
+	}
+	if err := db.Reindex(ctx, datatype.Name(), opts); !errors.Is(err, errStop) {
+		t.Fatalf("want errStop got %v", err)
+	}
+	if n := count(); n != 3 {
+		t.Fatalf("want 3 indexed objects got %d", n)
+	}
+	opts = &ReindexOptions{BatchSize: 3, StartAfter: checkpoint}
+	if err := db.Reindex(ctx, datatype.Name(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 10 {
+		t.Fatalf("want 10 indexed objects got %d", n)
+	}
+
+	// Obsolete index keys must be removed and unique keys must be claimed.
+	kvtx = kvdb.NewTx()
+	defer kvtx.Rollback(ctx)
+	it := new(kvmemdb.Iter)
+	if err := kvtx.Ascend(ctx, "/ix/TestReindex.Account/Old/", "/ix/TestReindex.Account/Old0", it); err == nil {
+		if k, _, err := it.GetNext(ctx); err == nil {
+			t.Fatalf("obsolete index key %s is not removed", k)
+		}
+	}
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	if err := tx.Store(ctx, "/accounts/new", &Account{Email: "5@x"}); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("want unique constraint failure got %v", err)
+	}
+
+	if err := db.Reindex(ctx, "TestReindex.Missing", nil); err == nil {
+		t.Fatalf("unregistered types must fail")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+
+	type Member struct {
+		Name  string `kodb:"index"`
+		Email string `kodb:"index,unique"`
+		Bio   string `kodb:"text"`
+	}
+
+	if err := internal.Register("TestCheck.Member", Member{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		m := &Member{Name: "m", Email: fmt.Sprintf("%d@x", i), Bio: "hello world"}
+		if err := tx.Store(ctx, fmt.Sprintf("/members/%d", i), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if problems, err := db.Check(ctx, &CheckOptions{BatchSize: 2}); err != nil {
+		t.Fatal(err)
+	} else if len(problems) != 0 {
+		t.Fatalf("want no problems got %v", problems)
+	}
+
+	// Corrupt the index with a missing index key, a dangling index key and a
+	// dangling unique key.
+	kvtx := kvdb.NewTx()
+	if err := kvtx.Delete(ctx, "/ix/TestCheck.Member/Name/m%00/ob/members/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvtx.Set(ctx, "/ft/TestCheck.Member/Bio/hello/ob/members/9", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvtx.Set(ctx, "/ux/TestCheck.Member/Email/9@x%00", "/ob/members/9"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvtx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := func(problems []*Problem) string {
+		var ks []string
+		for _, p := range problems {
+			ks = append(ks, fmt.Sprintf("%s:%s:%t", p.Kind, p.ObjectKey, p.Repaired))
+		}
+		return strings.Join(ks, ",")
+	}
+	want := "dangling-index-key:/members/9:false,missing-index-key:/members/1:false,dangling-unique-key:/members/9:false"
+	problems, err := db.Check(ctx, &CheckOptions{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := kinds(problems); got != want {
+		t.Fatalf("want %s got %s", want, got)
+	}
+
+	problems, err = db.Check(ctx, &CheckOptions{BatchSize: 2, Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := kinds(problems), strings.ReplaceAll(want, "false", "true"); got != want {
+		t.Fatalf("want %s got %s", want, got)
+	}
+	if problems, err := db.Check(ctx, nil); err != nil {
+		t.Fatal(err)
+	} else if len(problems) != 0 {
+		t.Fatalf("want no problems after repair got %v", problems)
+	}
+}
+
+func TestReadRepair(t *testing.T) {
+	ctx := context.Background()
+
+	type Item struct {
+		Name string `kodb:"index"`
+		Kind string `kodb:"index"`
+	}
+
+	if err := internal.Register("TestReadRepair.Item", Item{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := tx.Store(ctx, fmt.Sprintf("/items/%d", i), &Item{Name: "x", Kind: "k"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave stale index keys behind, for a missing object and for an object
+	// that no longer has the index key.
+	kvtx := kvdb.NewTx()
+	if err := kvtx.Delete(ctx, "/ob/items/0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvtx.Set(ctx, "/ix/TestReadRepair.Item/Name/y%00/ob/items/1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvtx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	find := func(readRepair bool, part *Item) int {
+		tx, err := db.NewTx(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx.SetReadRepair(readRepair)
+		var it Iter
+		if err := tx.FindByIndex(ctx, part, &it); err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, _, err := it.GetNext(ctx); err == nil; _, _, err = it.GetNext(ctx) {
+			n++
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	problems := func() int {
+		ps, err := db.Check(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(ps)
+	}
+
+	if n := find(false, &Item{Name: "x"}); n != 2 {
+		t.Fatalf("want 2 objects got %d", n)
+	}
+	if n := problems(); n != 3 {
+		t.Fatalf("want 3 stale index keys got %d", n)
+	}
+	if n := find(true, &Item{Name: "x"}); n != 2 {
+		t.Fatalf("want 2 objects got %d", n)
+	}
+	if n := find(true, &Item{Name: "y"}); n != 0 {
+		t.Fatalf("want no objects got %d", n)
+	}
+	// Stale index key of the Kind field for the missing object is not found
+	// through the lookups.
+	if n := problems(); n != 1 {
+		t.Fatalf("want 1 stale index key got %d", n)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+
+	type Note struct {
+		Tag string `kodb:"index"`
+	}
+
+	if err := internal.Register("TestErrors.Note", Note{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Get(ctx, "/missing"); !errors.Is(err, ErrNotFound) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want ErrNotFound got %v", err)
+	}
+	if err := tx.Load(ctx, "/missing", &Note{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound got %v", err)
+	}
+	if err := tx.Delete(ctx, "/missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want ErrNotFound got %v", err)
+	}
+	if _, err := tx.Get(ctx, "relative/key"); !errors.Is(err, ErrInvalidKey) || !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("want ErrInvalidKey got %v", err)
+	}
+	if err := tx.Set(ctx, "/string", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Load(ctx, "/string", &Note{}); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("want ErrTypeMismatch got %v", err)
+	}
+
+	// Missing index keys must not fail the updates and deletes.
+	if err := tx.Store(ctx, "/notes/1", &Note{Tag: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Store(ctx, "/notes/2", &Note{Tag: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.tx.Delete(ctx, "/ix/TestErrors.Note/Tag/a%00/ob/notes/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.tx.Delete(ctx, "/ix/TestErrors.Note/Tag/a%00/ob/notes/2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Store(ctx, "/notes/1", &Note{Tag: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete(ctx, "/notes/2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.tx.Set(ctx, "/ob/notes/3", "corrupted"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Store(ctx, "/notes/3", &Note{Tag: "c"}); !errors.Is(err, ErrIndexInconsistent) {
+		t.Fatalf("want ErrIndexInconsistent got %v", err)
+	}
+}
+
+func TestStreamingFindByIndex(t *testing.T) {
+	ctx := context.Background()
+
+	type Item struct {
+		ID     int
+		Parity string `kodb:"index"`
+		Mod3   int    `kodb:"index,zero"`
+		Group  string `kodb:"index=group_mod,1"`
+		Mod5   int    `kodb:"index=group_mod,2"`
+	}
+
+	if err := internal.Register("TestStreamingFindByIndex.Item", Item{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	const n = 300
+	parity := []string{"even", "odd"}
+	for i := 0; i < n; i++ {
+		item := &Item{ID: i, Parity: parity[i%2], Mod3: i % 3, Group: fmt.Sprintf("g%d", i%4), Mod5: i % 5}
+		if err := tx.Store(ctx, fmt.Sprintf("/items/%04d", i), item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	find := func(part *Item, fields ...string) []string {
+		var it Iter
+		if fields == nil {
+			if err := tx.FindByIndex(ctx, part, &it); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			if err := tx.FindByIndexFields(ctx, part, fields, &it); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var keys []string
+		var key string
+		var item Item
+		for err := it.LoadNext(ctx, &key, &item); err == nil; err = it.LoadNext(ctx, &key, &item) {
+			keys = append(keys, key)
+		}
+		return keys
+	}
+
+	want := func(match func(i int) bool) []string {
+		var keys []string
+		for i := 0; i < n; i++ {
+			if match(i) {
+				keys = append(keys, fmt.Sprintf("/items/%04d", i))
+			}
+		}
+		return keys
+	}
+
+	// Objects are returned in the object key order, except when only partial
+	// composite values are given.
+	testcases := []struct {
+		part      *Item
+		fields    []string
+		match     func(i int) bool
+		unordered bool
+	}{
+		{&Item{Parity: "odd"}, nil, func(i int) bool { return i%2 == 1 }, false},
+		{&Item{Parity: "even", Mod3: 0}, []string{"Parity", "Mod3"}, func(i int) bool { return i%6 == 0 }, false},
+		{&Item{Parity: "odd", Mod3: 2}, nil, func(i int) bool { return i%2 == 1 && i%3 == 2 }, false},
+		{&Item{Group: "g1", Mod5: 3}, nil, func(i int) bool { return i%4 == 1 && i%5 == 3 }, false},
+		// Composite index keys are not added when Mod5 is zero.
+		{&Item{Group: "g2"}, nil, func(i int) bool { return i%4 == 2 && i%5 != 0 }, true},
+		{&Item{Parity: "even", Group: "g2", Mod3: 1}, nil, func(i int) bool { return i%4 == 2 && i%5 != 0 && i%3 == 1 }, false},
+		{&Item{Parity: "odd", Group: "g2"}, nil, func(i int) bool { return false }, false},
+	}
+	for i, tc := range testcases {
+		got := find(tc.part, tc.fields...)
+		if tc.unordered {
+			sort.Strings(got)
+		}
+		if w := want(tc.match); strings.Join(got, ",") != strings.Join(w, ",") {
+			t.Fatalf("testcase %d: want %v got %v", i, w, got)
+		}
+	}
+
+	// Objects deleted while streaming are skipped.
+	var it Iter
+	if err := tx.FindByIndex(ctx, &Item{Parity: "even"}, &it); err != nil {
+		t.Fatal(err)
+	}
+	var key string
+	var item Item
+	if err := it.LoadNext(ctx, &key, &item); err != nil || key != "/items/0000" {
+		t.Fatalf("want /items/0000 got %q (%v)", key, err)
+	}
+	if err := tx.Delete(ctx, "/items/0002"); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.LoadNext(ctx, &key, &item); err != nil || key != "/items/0004" {
+		t.Fatalf("want /items/0004 got %q (%v)", key, err)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	ctx := context.Background()
+
+	type Event struct {
+		Name   string
+		Kind   string   `kodb:"index"`
+		Score  int      `kodb:"index"`
+		Labels []string `kodb:"index"`
+	}
+
+	if err := internal.Register("TestPagination.Event", Event{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 25
+	for i := 0; i < n; i++ {
+		ev := &Event{
+			Name:   fmt.Sprintf("e%02d", i),
+			Kind:   "click",
+			Score:  n - i,
+			Labels: []string{"a", "b", "c"},
+		}
+		if err := tx.Store(ctx, fmt.Sprintf("/events/%02d", i), ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// pages runs the query in a new transaction for every page and returns
+	// the names of the objects in all pages.
+	pages := func(limit int, query func(tx *Tx, it *Iter) error) (names []string, npages int) {
+		var cursor string
+		for {
+			tx, err := db.NewTx(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var it Iter
+			it.SetLimit(limit)
+			if err := it.SetCursor(cursor); err != nil {
+				t.Fatal(err)
+			}
+			if err := query(tx, &it); err != nil {
+				t.Fatal(err)
+			}
+			var ev Event
+			count := 0
+			for err := it.LoadNext(ctx, nil /* key */, &ev); err == nil; err = it.LoadNext(ctx, nil /* key */, &ev) {
+				names = append(names, ev.Name)
+				count++
+			}
+			if count > limit {
+				t.Fatalf("page has %d objects with limit %d", count, limit)
+			}
+			tx.Rollback(ctx)
+			npages++
+			if cursor = it.Cursor(); cursor == "" {
+				return names, npages
+			}
+		}
+	}
+
+	var byKey, byScore []string
+	for i := 0; i < n; i++ {
+		byKey = append(byKey, fmt.Sprintf("e%02d", i))
+		byScore = append(byScore, fmt.Sprintf("e%02d", n-1-i))
+	}
+
+	// Objects are ordered by the object keys for FindByIndex queries.
+	names, npages := pages(10, func(tx *Tx, it *Iter) error {
+		return tx.FindByIndex(ctx, &Event{Kind: "click", Labels: []string{"b"}}, it)
+	})
+	if strings.Join(names, ",") != strings.Join(byKey, ",") || npages != 3 {
+		t.Fatalf("want %v in 3 pages got %v in %d pages", byKey, names, npages)
+	}
+
+	// Objects are ordered by the field values for range queries.
+	names, _ = pages(7, func(tx *Tx, it *Iter) error {
+		return tx.FindByRange(ctx, &Event{}, "Score", 1, nil, it)
+	})
+	if strings.Join(names, ",") != strings.Join(byScore, ",") {
+		t.Fatalf("want %v got %v", byScore, names)
+	}
+
+	// Objects with multi-valued fields are not repeated across the pages.
+	names, _ = pages(4, func(tx *Tx, it *Iter) error {
+		return tx.FindByRange(ctx, &Event{}, "Labels", "a", "c", it)
+	})
+	if strings.Join(names, ",") != strings.Join(byKey, ",") {
+		t.Fatalf("want %v got %v", byKey, names)
+	}
+
+	// Limit and cursor apply only to the next query through the iterator.
+	tx, err = db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	count := func(it *Iter) int {
+		n := 0
+		for _, _, err := it.GetNext(ctx); err == nil; _, _, err = it.GetNext(ctx) {
+			n++
+		}
+		return n
+	}
+	var it Iter
+	it.SetLimit(5)
+	if err := tx.FindByIndex(ctx, &Event{Kind: "click"}, &it); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(&it); got != 5 {
+		t.Fatalf("want 5 objects got %d", got)
+	}
+	if err := it.SetCursor(it.Cursor()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.FindByIndex(ctx, &Event{Kind: "click"}, &it); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(&it); got != n-5 {
+		t.Fatalf("want %d objects got %d", n-5, got)
+	}
+	if err := tx.FindByRange(ctx, &Event{}, "Score", nil, nil, &it); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(&it); got != n {
+		t.Fatalf("want %d objects got %d", n, got)
+	}
+
+	if err := it.SetCursor("not a cursor"); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("want os.ErrInvalid got %v", err)
+	}
+}
+
+func TestFindByQuery(t *testing.T) {
+	ctx := context.Background()
+
+	type Account struct {
+		Name   string
+		Status string   `kodb:"index"`
+		Age    int      `kodb:"index"`
+		Tags   []string `kodb:"index"`
+		Plan   string
+		Score  float64
+	}
+
+	if err := internal.Register("TestFindByQuery.Account", Account{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	accounts := []*Account{
+		{Name: "a", Status: "open", Age: 17, Tags: []string{"x"}, Plan: "free"},
+		{Name: "b", Status: "open", Age: 30, Tags: []string{"x", "y"}, Plan: "pro", Score: 1.5},
+		{Name: "c", Status: "pending", Age: 45, Tags: []string{"y"}, Plan: "free"},
+		{Name: "d", Status: "closed", Age: 18, Plan: "pro"},
+		{Name: "e", Status: "pending", Age: 18, Tags: []string{"x", "z"}},
+	}
+	for _, v := range accounts {
+		if err := tx.Store(ctx, path.Join("/accounts", v.Name), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Objects of other types must not be matched in the object scans.
+	if err := tx.Set(ctx, "/other", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	find := func(q *Query) []string {
+		var it Iter
+		if err := tx.FindByQuery(ctx, &Account{}, q, &it); err != nil {
+			t.Fatal(err)
+		}
+		var matched []string
+		var account Account
+		for err := it.LoadNext(ctx, nil /* key */, &account); err == nil; err = it.LoadNext(ctx, nil /* key */, &account) {
+			matched = append(matched, account.Name)
+		}
+		sort.Strings(matched)
+		return matched
+	}
+
+	testcases := []struct {
+		query *Query
+		want  []string
+	}{
+		{Where("Status").Eq("open"), []string{"a", "b"}},
+		{Where("Status").In("open", "pending"), []string{"a", "b", "c", "e"}},
+		{Where("Status").In("open", "pending").And(Where("Age").Gt(18)), []string{"b", "c"}},
+		{Where("Age").Ge(18), []string{"b", "c", "d", "e"}},
+		{Where("Age").Lt(18), []string{"a"}},
+		{Where("Age").Le(18), []string{"a", "d", "e"}},
+		{Where("Age").Between(18, 30), []string{"b", "d", "e"}},
+		{Where("Age").Lt(uint64(1 << 63)), []string{"a", "b", "c", "d", "e"}},
+		{Where("Age").Eq(uint64(1 << 63)), nil},
+		{Where("Status").HasPrefix("p"), []string{"c", "e"}},
+		{Where("Tags").In("x", "y"), []string{"a", "b", "c", "e"}},
+		{Where("Tags").Eq("x").And(Where("Tags").Eq("y")), []string{"b"}},
+		{Where("Status").Eq("open").Or(Where("Age").Eq(18)), []string{"a", "b", "d", "e"}},
+		{Where("Age").Ge(18).Not(Where("Status").Eq("pending")), []string{"b", "d"}},
+		{Not(Where("Status").Eq("open")), []string{"c", "d", "e"}},
+		// Fields without an index are checked against the objects.
+		{Where("Plan").Eq("free"), []string{"a", "c"}},
+		{Where("Plan").Eq(""), []string{"e"}},
+		{Where("Plan").Eq("pro").And(Where("Age").Gt(20)), []string{"b"}},
+		{Where("Score").Gt(1), []string{"b"}},
+		{Where("Plan").Eq("pro").Or(Where("Status").Eq("pending")), []string{"b", "c", "d", "e"}},
+	}
+	for i, tc := range testcases {
+		got := find(tc.query)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Fatalf("testcase %d: want %v got %v", i, tc.want, got)
+		}
+	}
+
+	// Results can be paged through with the index scans and object scans.
+	pagecases := []struct {
+		query *Query
+		want  string
+	}{
+		{Where("Tags").In("x", "y", "z"), "a,b,c,e"},
+		{Where("Plan").In("free", "pro", ""), "a,b,c,d,e"},
+	}
+	for i, tc := range pagecases {
+		q := tc.query
+		var names []string
+		var cursor string
+		for {
+			var it Iter
+			it.SetLimit(2)
+			if err := it.SetCursor(cursor); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.FindByQuery(ctx, &Account{}, q, &it); err != nil {
+				t.Fatal(err)
+			}
+			var account Account
+			for err := it.LoadNext(ctx, nil /* key */, &account); err == nil; err = it.LoadNext(ctx, nil /* key */, &account) {
+				names = append(names, account.Name)
+			}
+			if cursor = it.Cursor(); cursor == "" {
+				break
+			}
+		}
+		sort.Strings(names)
+		if strings.Join(names, ",") != tc.want {
+			t.Fatalf("pagecase %d: want %v got %v", i, tc.want, names)
+		}
+	}
+
+	// Zero values are not indexed, so predicates matching them are checked
+	// against the objects.
+	if err := tx.Store(ctx, "/accounts/f", &Account{Name: "f"}); err != nil {
+		t.Fatal(err)
+	}
+	zerocases := []struct {
+		query *Query
+		want  []string
+	}{
+		{Where("Status").Eq(""), []string{"f"}},
+		{Where("Status").In("", "closed"), []string{"d", "f"}},
+		{Where("Status").Lt("o"), []string{"d", "f"}},
+		{Where("Status").Gt(""), []string{"a", "b", "c", "d", "e"}},
+		{Where("Age").Eq(0), []string{"f"}},
+		{Where("Age").Lt(18), []string{"a", "f"}},
+		{Where("Age").Ge(18), []string{"b", "c", "d", "e"}},
+		{Not(Where("Age").Lt(18)), []string{"b", "c", "d", "e"}},
+	}
+	for i, tc := range zerocases {
+		got := find(tc.query)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Fatalf("zerocase %d: want %v got %v", i, tc.want, got)
+		}
+	}
+
+	var it Iter
+	if err := tx.FindByQuery(ctx, &Account{}, Where("Missing").Eq(1), &it); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("want os.ErrInvalid got %v", err)
+	}
+	if err := tx.FindByQuery(ctx, &Account{}, Where("Age").Eq("old"), &it); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("want os.ErrInvalid got %v", err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	ctx := context.Background()
+
+	type Member struct {
+		Email  string `kodb:"index"`
+		Status string `kodb:"index"`
+		Age    int    `kodb:"index"`
+		Note   string
+	}
+
+	if err := internal.Register("TestExplain.Member", Member{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	const n = 200
+	for i := 0; i < n; i++ {
+		m := &Member{Email: fmt.Sprintf("m%03d@x", i), Status: "active", Age: 20 + i%50}
+		if err := tx.Store(ctx, fmt.Sprintf("/members/%03d", i), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var it Iter
+	if p := it.Explain(); p != nil {
+		t.Fatalf("want nil plan for uninitialized iterator got %v", p)
+	}
+
+	// Most selective index drives the query and others are only checked.
+	if err := tx.FindByIndex(ctx, &Member{Status: "active", Email: "m042@x"}, &it); err != nil {
+		t.Fatal(err)
+	}
+	var key string
+	var m Member
+	if err := it.LoadNext(ctx, &key, &m); err != nil || key != "/members/042" {
+		t.Fatalf("want /members/042 got %q (%v)", key, err)
+	}
+	if err := it.LoadNext(ctx, &key, &m); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want os.ErrNotExist got %v", err)
+	}
+	p := it.Explain()
+	if p.Index != "Email" || p.Estimate != 1 || len(p.Candidates) != 2 || p.Filters != 1 {
+		t.Fatalf("unexpected plan %+v", p)
+	}
+	if p.KeysExamined != 2 || p.ObjectsLoaded != 1 || p.ObjectsReturned != 1 {
+		t.Fatalf("unexpected keys examined in plan %s", p)
+	}
+
+	q := Where("Status").Eq("active").And(Where("Age").Between(20, 21))
+	if err := tx.FindByQuery(ctx, &Member{}, q, &it); err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for err := it.LoadNext(ctx, nil /* key */, &m); err == nil; err = it.LoadNext(ctx, nil /* key */, &m) {
+		count++
+	}
+	if p := it.Explain(); count != 8 || p.Index != "Age" || p.Estimate != 8 || p.KeysExamined != 8 || p.ObjectsReturned != 8 {
+		t.Fatalf("unexpected plan %s for %d objects", p, count)
+	}
+
+	// Ranges with the zero value cannot be answered from the index, because
+	// zero values are not indexed.
+	q = Where("Status").Eq("active").And(Where("Age").Lt(22))
+	if err := tx.FindByQuery(ctx, &Member{}, q, &it); err != nil {
+		t.Fatal(err)
+	}
+	count = 0
+	for err := it.LoadNext(ctx, nil /* key */, &m); err == nil; err = it.LoadNext(ctx, nil /* key */, &m) {
+		count++
+	}
+	if p := it.Explain(); count != 8 || p.Index != "Status" || len(p.Candidates) != 1 {
+		t.Fatalf("unexpected plan %s for %d objects", p, count)
+	}
+
+	if err := tx.FindByQuery(ctx, &Member{}, Where("Note").Eq(""), &it); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.LoadNext(ctx, nil /* key */, &m); err != nil {
+		t.Fatal(err)
+	}
+	if p := it.Explain(); p.Index != "" || p.KeysExamined != 1 {
+		t.Fatalf("want an object scan plan got %s", p)
+	}
+}
+
+func TestRunStatement(t *testing.T) {
+	ctx := context.Background()
+
+	type User struct {
+		Name    string
+		Age     int    `kodb:"index"`
+		Status  string `kodb:"index"`
+		Admin   bool
+		Score   float64
+		Joined  time.Time `kodb:"index"`
+		Level   uint64    `kodb:"index"`
+		Balance int       `kodb:"index"`
+		Credit  int
+	}
+
+	if err := internal.Register("TestRunStatement.User", User{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	date := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	users := []*User{
+		{Name: "alice", Age: 30, Status: "active", Admin: true, Score: 4.5, Joined: date("2021-01-15T00:00:00Z"), Level: 1, Balance: 10, Credit: 10},
+		{Name: "bob", Age: 17, Status: "trial", Score: 3, Joined: date("2021-02-01T00:00:00Z"), Balance: -5, Credit: -5},
+		{Name: "carol", Age: 45, Status: "trial", Score: 2.5, Joined: date("2020-06-30T00:00:00Z"), Level: math.MaxUint64},
+		{Name: "dave", Age: 18, Status: "closed", Joined: date("2021-03-10T00:00:00Z"), Balance: 3, Credit: 3},
+		{Name: "erin", Age: 25, Status: "active", Score: 5, Joined: date("2021-04-01T12:00:00+02:00"), Balance: -1, Credit: -1},
+		{Name: "frank"},
+	}
+	for _, u := range users {
+		if err := tx.Store(ctx, path.Join("/users", u.Name), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testcases := []struct {
+		text string
+		want []string
+	}{
+		{`TestRunStatement.User`, []string{"alice", "bob", "carol", "dave", "erin", "frank"}},
+		{`TestRunStatement.User where Age >= 18 and Status in ("active", "trial") order by Age limit 2`, []string{"erin", "alice"}},
+		{`TestRunStatement.User WHERE Age >= 18 AND Status IN ('active', 'trial') ORDER BY Age DESC`, []string{"carol", "alice", "erin"}},
+		{`TestRunStatement.User where Status = "trial" or (Age < 20 and not Status = "trial") order by Name`, []string{"bob", "carol", "dave", "frank"}},
+		{`TestRunStatement.User where Status != "active" order by Name`, []string{"bob", "carol", "dave", "frank"}},
+		{`TestRunStatement.User order by Age`, []string{"frank", "bob", "dave", "erin", "alice", "carol"}},
+		{`TestRunStatement.User order by Age desc`, []string{"carol", "alice", "erin", "dave", "bob", "frank"}},
+		{`TestRunStatement.User order by Age desc limit 2`, []string{"carol", "alice"}},
+		{`TestRunStatement.User where Status = "active" or Admin = false order by Level desc limit 3`, []string{"carol", "alice", "frank"}},
+		{`TestRunStatement.User where Joined >= time "2021-02-01T00:00:00Z" order by Joined desc`, []string{"erin", "dave", "bob"}},
+		{`TestRunStatement.User where Joined between time "2021-01-15T00:00:00Z" and time "2021-03-10T01:00:00+01:00" order by Joined`, []string{"alice", "bob", "dave"}},
+		{`TestRunStatement.User where Level > -1 order by Level`, []string{"bob", "dave", "erin", "frank", "alice", "carol"}},
+		// Indexed and other fields are ordered the same way.
+		{`TestRunStatement.User order by Balance`, []string{"bob", "erin", "carol", "frank", "dave", "alice"}},
+		{`TestRunStatement.User order by Credit`, []string{"bob", "erin", "carol", "frank", "dave", "alice"}},
+		{`TestRunStatement.User order by Balance desc limit 4`, []string{"alice", "dave", "frank", "carol"}},
+		{`TestRunStatement.User where Balance < 0 order by Balance desc`, []string{"erin", "bob"}},
+		{`TestRunStatement.User where Level = 18446744073709551615`, []string{"carol"}},
+		{`TestRunStatement.User where Level < -1`, nil},
+		{`TestRunStatement.User where Age between 18 and 30 order by Score desc`, []string{"erin", "alice", "dave"}},
+		{`TestRunStatement.User where Name like "car%"`, []string{"carol"}},
+		{`TestRunStatement.User where Admin = true`, []string{"alice"}},
+		{`TestRunStatement.User where Status = ""`, []string{"frank"}},
+		{`TestRunStatement.User where Score > 2.75 order by Score limit 5`, []string{"bob", "alice", "erin"}},
+		{`"TestRunStatement.User" limit 1`, []string{"alice"}},
+	}
+	for i, tc := range testcases {
+		rows, err := tx.RunStatement(ctx, tc.text)
+		if err != nil {
+			t.Fatalf("testcase %d: %v", i, err)
+		}
+		var got []string
+		for _, row := range rows {
+			got = append(got, row.Object.(*User).Name)
+			if row.Key != path.Join("/users", row.Object.(*User).Name) {
+				t.Fatalf("testcase %d: unexpected key %s", i, row.Key)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Fatalf("testcase %d: want %v got %v", i, tc.want, got)
+		}
+	}
+
+	// Type and field names can have Unicode letters.
+	type Messung struct {
+		Höhe int `kodb:"index"`
+	}
+	if err := internal.Register("TestRunStatement.Maß", Messung{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+	for i, h := range []int{1, 2, 3} {
+		if err := tx.Store(ctx, fmt.Sprintf("/messungen/%d", i), &Messung{Höhe: h}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rows, err := tx.RunStatement(ctx, `TestRunStatement.Maß where Höhe >= 2 order by Höhe desc`); err != nil {
+		t.Fatal(err)
+	} else if len(rows) != 2 || rows[0].Object.(*Messung).Höhe != 3 {
+		t.Fatalf("want 2 rows ordered by Höhe got %d rows", len(rows))
+	}
+
+	// Statements ordered by an indexed field read objects in the index order.
+	datatype, err := internal.GetDataTypeByName("TestRunStatement.User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := tx.newOrderedStream(ctx, datatype, nil, "Age", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var it Iter
+	it.SetLimit(2)
+	it.reset(tx, stream)
+	if rows, err := loadRows(ctx, datatype, &it); err != nil || len(rows) != 2 {
+		t.Fatalf("want 2 rows got %d rows (%v)", len(rows), err)
+	}
+	if p := it.Explain(); p.Index != "Age" || p.ObjectsLoaded != 2 {
+		t.Fatalf("want 2 objects loaded from Age index got %+v", p)
+	}
+
+	invalid := []string{
+		``,
+		`TestRunStatement.User where`,
+		`TestRunStatement.User where Age >`,
+		`TestRunStatement.User where Age in (1, 2`,
+		`TestRunStatement.User where (Age = 1`,
+		`TestRunStatement.User where Name like "a%b"`,
+		`TestRunStatement.User where Age = 1.5`,
+		`TestRunStatement.User where Missing = 1`,
+		`TestRunStatement.User order Age`,
+		`TestRunStatement.User limit 0`,
+		`TestRunStatement.User where Name = "x" extra`,
+		`TestRunStatement.User where Name = "unterminated`,
+		`TestRunStatement.User where Name ~ "x"`,
+		`TestRunStatement.User where Name ≠ "x"`,
+		`TestRunStatement.User where Joined = time 5`,
+		`TestRunStatement.User where Joined = time "yesterday"`,
+		`TestRunStatement.User where Level = 18446744073709551616`,
+	}
+	for i, text := range invalid {
+		if _, err := tx.RunStatement(ctx, text); !errors.Is(err, os.ErrInvalid) {
+			t.Fatalf("invalid %d: want os.ErrInvalid got %v", i, err)
+		}
+	}
+	if _, err := tx.RunStatement(ctx, "NoSuchType"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want os.ErrNotExist got %v", err)
+	}
+}
+
+func TestCountByIndex(t *testing.T) {
+	ctx := context.Background()
+
+	type Item struct {
+		Name string `kodb:"index"`
+		Kind string `kodb:"index"`
+	}
+
+	if err := internal.Register("TestCountByIndex.Item", Item{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := tx.Store(ctx, fmt.Sprintf("/items/%d", i), &Item{Name: "x", Kind: fmt.Sprintf("k%d", i%2)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave stale index keys behind, which must not be counted.
+	kvtx := kvdb.NewTx()
+	if err := kvtx.Delete(ctx, "/ob/items/0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvtx.Set(ctx, "/ix/TestCountByIndex.Item/Name/y%00/ob/items/1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvtx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	type testCase struct {
+		part  *Item
+		count int
+	}
+	testCases := []testCase{
+		{&Item{Name: "x"}, 4},
+		{&Item{Kind: "k0"}, 2},
+		{&Item{Kind: "k1"}, 2},
+		{&Item{Name: "x", Kind: "k0"}, 2},
+		{&Item{Name: "y"}, 0},
+		{&Item{Name: "z"}, 0},
+	}
+	for i, tc := range testCases {
+		n, err := tx.CountByIndex(ctx, tc.part)
+		if err != nil {
+			t.Fatalf("%d: count failed: %v", i, err)
+		}
+		if n != tc.count {
+			t.Errorf("%d: want %d objects, got %d", i, tc.count, n)
+		}
+		ok, err := tx.ExistsByIndex(ctx, tc.part)
+		if err != nil {
+			t.Fatalf("%d: exists failed: %v", i, err)
+		}
+		if ok != (tc.count > 0) {
+			t.Errorf("%d: want exists %t, got %t", i, tc.count > 0, ok)
+		}
+	}
+}
+
+func TestDistinctValues(t *testing.T) {
+	ctx := context.Background()
+
+	type Item struct {
+		Status string   `kodb:"index"`
+		Level  int      `kodb:"index"`
+		Tags   []string `kodb:"index"`
+	}
+
+	if err := internal.Register("TestDistinctValues.Item", Item{}); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+
+	var kvdb kvmemdb.DB
+	newTx := func(context.Context) (kv.Transaction, error) { return kvdb.NewTx(), nil }
+	newIt := func(context.Context) (kv.Iterator, error) { return new(kvmemdb.Iter), nil }
+	db := New(newTx, newIt)
+
+	tx, err := db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []string{"open", "closed", "opened", "pending"}
+	for i := 0; i < 10; i++ {
+		item := &Item{
+			Status: statuses[i%len(statuses)],
+			Level:  i%3 - 1,
+			Tags:   []string{"all", fmt.Sprintf("t%d", i%2)},
+		}
+		if err := tx.Store(ctx, fmt.Sprintf("/items/%d", i), item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave a stale index key behind for a value no object has.
+	kvtx := kvdb.NewTx()
+	if err := kvtx.Set(ctx, "/ix/TestDistinctValues.Item/Status/stale%00/ob/items/1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvtx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.NewTx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	format := func(values []*DistinctValue) string {
+		var parts []string
+		for _, v := range values {
+			parts = append(parts, fmt.Sprintf("%v=%d", v.Value, v.Count))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	type testCase struct {
+		field string
+		opts  *DistinctOptions
+		want  string
+	}
+	testCases := []testCase{
+		{"Status", nil, "closed=3 open=3 opened=2 pending=2 stale=1"},
+		{"Status", &DistinctOptions{Verify: true}, "closed=3 open=3 opened=2 pending=2"},
+		{"Status", &DistinctOptions{Prefix: "open"}, "open=3 opened=2"},
+		{"Status", &DistinctOptions{Prefix: "x"}, ""},
+		// Zero values are not indexed without the zero option.
+		{"Level", nil, "-1=4 1=3"},
+		{"Tags", nil, "all=10 t0=5 t1=5"},
+	}
+	for i, tc := range testCases {
+		values, cursor, err := tx.DistinctValues(ctx, &Item{}, tc.field, tc.opts)
+		if err != nil {
+			t.Fatalf("%d: distinct values failed: %v", i, err)
+		}
+		if s := format(values); s != tc.want {
+			t.Errorf("%d: want %q, got %q", i, tc.want, s)
+		}
+		if len(cursor) != 0 {
+			t.Errorf("%d: want no cursor without a limit, got %q", i, cursor)
+		}
+	}
+
+	// Values with only stale index keys are not counted against the limit.
+	var pages []string
+	opts := &DistinctOptions{Limit: 2, Verify: true}
+	for {
+		values, cursor, err := tx.DistinctValues(ctx, &Item{}, "Status", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, format(values))
+		if len(cursor) == 0 {
+			break
+		}
+		opts.Cursor = cursor
+	}
+	if s := strings.Join(pages, "|"); s != "closed=3 open=3|opened=2 pending=2" {
+		t.Errorf("unexpected pages %q", s)
+	}
+
+	if _, _, err := tx.DistinctValues(ctx, &Item{}, "Level", &DistinctOptions{Prefix: "1"}); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("want os.ErrInvalid for a prefix on integer field, got %v", err)
+	}
+	if _, _, err := tx.DistinctValues(ctx, &Item{}, "Missing", nil); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("want os.ErrInvalid for an unindexed field, got %v", err)
+	}
+	if _, _, err := tx.DistinctValues(ctx, &Item{}, "Status", &DistinctOptions{Cursor: "%%"}); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("want os.ErrInvalid for an invalid cursor, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package kodb
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bvkgo/kodb/internal"
+)
+
+// DistinctOptions holds optional parameters for the DistinctValues api.
+type DistinctOptions struct {
+	// Prefix when non-empty, limits the values to the string values beginning
+	// with the prefix.
+	Prefix string
+
+	// Limit when positive, limits the number of values returned.
+	Limit int
+
+	// Cursor when non-empty, resumes from the value after the cursor returned
+	// by an earlier call with the same type, field and prefix.
+	Cursor string
+
+	// Verify when true, validates the index keys with their objects, so that
+	// stale index keys are not counted. Objects are read, but not unmarshaled.
+	Verify bool
+}
+
+// DistinctValue holds a distinct value of an indexed field and the number of
+// objects with the value.
+type DistinctValue struct {
+	// Key holds the value in it's index key form, which sorts in the same order
+	// as the values.
+	Key string
+
+	// Value holds the value decoded into the field's type. Value is nil when
+	// the index key form cannot be decoded, which is the case for the values
+	// converted with user-defined conversions and the encoding.TextMarshaler
+	// or fmt.Stringer interfaces.
+	Value interface{}
+
+	// Count holds the number of objects with the value.
+	Count int
+}
+
+// DistinctValues returns the distinct values of an indexed field, in the
+// ascending order, with the number of objects for each value. Input object is
+// only used to identify the data type. Values are read from the index keys
+// alone, so objects are not loaded unless the Verify option is set.
+//
+// When the number of values is limited, a non-empty cursor is returned if
+// there are more values, which can be passed in the options to resume from the
+// next value.
+func (t *Tx) DistinctValues(ctx context.Context, sample interface{}, field string, opts *DistinctOptions) ([]*DistinctValue, string, error) {
+	if opts == nil {
+		opts = new(DistinctOptions)
+	}
+
+	datatype, err := internal.GetDataType(sample)
+	if err != nil {
+		return nil, "", err
+	}
+	var r [2]string
+	if len(opts.Prefix) > 0 {
+		r, err = datatype.IndexPrefixRange(field, opts.Prefix)
+	} else {
+		r, err = datatype.IndexValueRange(field, nil, nil)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if len(opts.Cursor) > 0 {
+		s, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor (%v): %w", err, os.ErrInvalid)
+		}
+		// Cursors hold an index key of the last value returned.
+		ik, err := internal.ParseIndexKey(string(s))
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor: %w", err)
+		}
+		vr, err := ik.IndexKeyRange()
+		if err != nil {
+			return nil, "", err
+		}
+		if vr[1] > r[0] {
+			r[0] = vr[1]
+		}
+	}
+	it, err := t.ascend(ctx, r)
+	if err != nil || it == nil {
+		return nil, "", err
+	}
+
+	var values []*DistinctValue
+	var cur *DistinctValue
+	var first, last string
+	for {
+		k, _, err := it.GetNext(ctx)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				return nil, "", err
+			}
+			break
+		}
+		ik, err := internal.ParseIndexKey(k)
+		if err != nil {
+			return nil, "", err
+		}
+		fvalue, err := ik.GetFieldValue()
+		if err != nil {
+			return nil, "", err
+		}
+		if cur == nil || cur.Key != fvalue {
+			// Values with only stale index keys are dropped.
+			if cur != nil && cur.Count > 0 {
+				values = append(values, cur)
+				last = first
+			}
+			cur = &DistinctValue{Key: fvalue}
+			cur.Value, _ = datatype.ParseIndexValue(field, fvalue)
+			first = k
+		}
+		if opts.Verify {
+			okey, err := ik.GetObjectKey()
+			if err != nil {
+				return nil, "", err
+			}
+			if _, err := t.getRef(ctx, okey, []internal.IndexKey{ik}); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return nil, "", err
+			}
+		}
+		// Cursor is returned only when there is at least one more value.
+		if cur.Count == 0 && opts.Limit > 0 && len(values) == opts.Limit {
+			return values, base64.RawURLEncoding.EncodeToString([]byte(last)), nil
+		}
+		cur.Count++
+	}
+	if cur != nil && cur.Count > 0 {
+		values = append(values, cur)
+	}
+	return values, "", nil
+}
@@ -0,0 +1,50 @@
+package kodb
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bvkgo/kodb/internal"
+)
+
+var (
+	// ErrNotFound is returned when no value exists at a key. It also matches
+	// the os.ErrNotExist error with errors.Is function.
+	ErrNotFound = internal.ErrNotFound
+
+	// ErrTypeMismatch is returned when an object is not of the expected data
+	// type. It also matches the os.ErrInvalid error with errors.Is function.
+	ErrTypeMismatch = internal.ErrTypeMismatch
+
+	// ErrInvalidKey is returned for malformed keys. It also matches the
+	// os.ErrInvalid error with errors.Is function.
+	ErrInvalidKey = internal.ErrInvalidKey
+
+	// ErrIndexInconsistent is returned when the index and the objects are found
+	// to be out of sync in a way that cannot be repaired automatically. The
+	// Check and Reindex apis can be used to fix such problems.
+	ErrIndexInconsistent = internal.ErrIndexInconsistent
+)
+
+// UniqueError is returned when an object cannot be stored because another
+// object already holds the same value for an unique index field.
+type UniqueError struct {
+	// Type holds the data type name.
+	Type string
+
+	// Field holds the unique index field name.
+	Field string
+
+	// Key holds the user key of the object that holds the field value.
+	Key string
+}
+
+func (e *UniqueError) Error() string {
+	return fmt.Sprintf("unique field %s of type %s is already in use by %s", e.Field, e.Type, e.Key)
+}
+
+// Is returns true for os.ErrExist, so that errors.Is(err, os.ErrExist) can be
+// used to identify unique constraint failures.
+func (e *UniqueError) Is(target error) bool {
+	return target == os.ErrExist
+}
@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/bvkgo/kv v0.0.0-20210808221408-e27312603f8e
 	github.com/bvkgo/kvmemdb v0.0.0-20210813014201-5879b3cc5125
+	golang.org/x/text v0.13.0
 )
 
 require golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
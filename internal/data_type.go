@@ -4,8 +4,20 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"sort"
 )
 
+// indexValuer is implemented by data types with computed index values, which
+// are indexed in addition to the index fields. Values are indexed as strings
+// under the index names used as the keys in the map, which must be declared by
+// the IndexNames method.
+type indexValuer interface {
+	IndexNames() []string
+	IndexValues() map[string][]string
+}
+
+var indexValuerType = reflect.TypeOf((*indexValuer)(nil)).Elem()
+
 type DataType struct {
 	// gotype holds the reflect.Type of reflect.Struct kind for the data type.
 	gotype reflect.Type
@@ -18,6 +30,20 @@ type DataType struct {
 	// automatically.
 	indexFields []*IndexField
 
+	// compositeIndexes holds metadata for indexes over multiple struct fields.
+	compositeIndexes []*CompositeIndex
+
+	// textFields holds metadata for struct fields with full-text indexes.
+	textFields []*IndexField
+
+	// projectFields holds metadata for struct fields that are stored in the
+	// values of the index keys.
+	projectFields []*IndexField
+
+	// computedNames holds the computed index names declared by the data
+	// types implementing the indexValuer interface to index derived values.
+	computedNames []string
+
 	cloner func(interface{}) (interface{}, error)
 
 	marshaler func(interface{}) (string, error)
@@ -31,25 +57,81 @@ func NewDataType(name string, sample interface{}) (*DataType, error) {
 		return nil, fmt.Errorf("input object must be a struct or pointer to struct: %w", os.ErrInvalid)
 	}
 
-	var indexFields []*IndexField
-	for i := 0; i < stype.NumField(); i++ {
-		sfield := stype.Field(i)
-		ifields, err := NewIndexFields(sfield)
-		if err != nil {
-			return nil, fmt.Errorf("couldn't determine index fields: %w", err)
+	ifields, err := NewStructIndexFields(stype)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't determine index fields: %w", err)
+	}
+	var indexFields, members, textFields, projectFields []*IndexField
+	for _, ifield := range ifields {
+		if ifield.project {
+			projectFields = append(projectFields, ifield)
+		} else if ifield.text {
+			textFields = append(textFields, ifield)
+		} else if len(ifield.composite) > 0 {
+			members = append(members, ifield)
+		} else {
+			indexFields = append(indexFields, ifield)
+		}
+	}
+	cindexes, err := NewCompositeIndexes(members)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't determine composite indexes: %w", err)
+	}
+	for _, c := range cindexes {
+		for _, ifield := range indexFields {
+			if ifield.name == c.name {
+				return nil, fmt.Errorf("composite index name %s conflicts with an index field: %w", c.name, os.ErrInvalid)
+			}
 		}
-		indexFields = append(indexFields, ifields...)
 	}
 	t := &DataType{
-		gotype:      stype,
-		name:        name,
-		indexFields: indexFields,
-		marshaler:   gobMarshalString,
-		unmarshaler: gobUnmarshalString,
+		gotype:           stype,
+		name:             name,
+		indexFields:      indexFields,
+		compositeIndexes: cindexes,
+		textFields:       textFields,
+		projectFields:    projectFields,
+		marshaler:        gobMarshalString,
+		unmarshaler:      gobUnmarshalString,
+	}
+	if err := t.initComputedNames(); err != nil {
+		return nil, err
 	}
 	return t, nil
 }
 
+// initComputedNames validates and saves the computed index names declared by
+// the data type. Index names are taken from a zero value of the data type.
+func (t *DataType) initComputedNames() error {
+	ptype := reflect.PtrTo(t.gotype)
+	if !ptype.Implements(indexValuerType) {
+		if _, ok := ptype.MethodByName("IndexValues"); ok {
+			return fmt.Errorf("data type %s with computed indexes must declare the index names with an IndexNames method: %w", t.name, os.ErrInvalid)
+		}
+		return nil
+	}
+	names := make(map[string]bool)
+	for _, name := range reflect.New(t.gotype).Interface().(indexValuer).IndexNames() {
+		if len(name) == 0 {
+			return fmt.Errorf("computed index name cannot be empty: %w", os.ErrInvalid)
+		}
+		if names[name] {
+			return fmt.Errorf("computed index name %s is declared more than once: %w", name, os.ErrInvalid)
+		}
+		if t.isCompositeIndex(name) {
+			return fmt.Errorf("computed index name %s conflicts with an index field: %w", name, os.ErrInvalid)
+		}
+		for _, ifield := range t.indexFields {
+			if ifield.name == name {
+				return fmt.Errorf("computed index name %s conflicts with an index field: %w", name, os.ErrInvalid)
+			}
+		}
+		names[name] = true
+		t.computedNames = append(t.computedNames, name)
+	}
+	return nil
+}
+
 func (t *DataType) goodValue(ob interface{}) (reflect.Value, bool) {
 	ovalue, ok := getStructValue(ob)
 	if !ok {
@@ -64,51 +146,419 @@ func (t *DataType) goodValue(ob interface{}) (reflect.Value, bool) {
 	return ovalue, true
 }
 
-func (t *DataType) IndexKeyMap(ob interface{}) (map[string]IndexKey, error) {
+// Name returns the registered type name for the data type.
+func (t *DataType) Name() string {
+	return t.name
+}
+
+// New returns a pointer to a new zero value of the data type.
+func (t *DataType) New() interface{} {
+	return reflect.New(t.gotype).Interface()
+}
+
+func (t *DataType) getIndexField(name string) (*IndexField, error) {
+	for _, ifield := range t.indexFields {
+		if ifield.name == name {
+			return ifield, nil
+		}
+	}
+	// Computed indexes have string values.
+	if t.isComputedIndex(name) {
+		return &IndexField{name: name, vtype: reflect.TypeOf("")}, nil
+	}
+	return nil, fmt.Errorf("field %s is not an indexed field of %s type: %w", name, t.name, os.ErrInvalid)
+}
+
+// isComputedIndex returns true if the name is a declared computed index name.
+func (t *DataType) isComputedIndex(name string) bool {
+	for _, c := range t.computedNames {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *DataType) isCompositeIndex(name string) bool {
+	for _, c := range t.compositeIndexes {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUniqueField returns true if the field is an unique index field.
+func (t *DataType) IsUniqueField(fieldName string) bool {
+	ifield, err := t.getIndexField(fieldName)
+	if err != nil {
+		return false
+	}
+	return ifield.unique
+}
+
+// IsMultiValued returns true if an object can have more than one index key
+// for the field, which includes the computed indexes.
+func (t *DataType) IsMultiValued(fieldName string) bool {
+	for _, ifield := range t.indexFields {
+		if ifield.name == fieldName {
+			return ifield.multi
+		}
+	}
+	return t.isComputedIndex(fieldName)
+}
+
+// IndexValueRange returns the index keyspace range for all index keys of a
+// field with values in the closed interval [lo, hi]. A nil lo or hi value
+// leaves the range unbounded on that side.
+func (t *DataType) IndexValueRange(fieldName string, lo, hi interface{}) ([2]string, error) {
+	ifield, err := t.getIndexField(fieldName)
+	if err != nil {
+		return [2]string{}, err
+	}
+	return ifield.valueRange(t.name, lo, hi, false, false)
+}
+
+// IndexPrefixRange returns the index keyspace range for all index keys of a
+// string field with values beginning with the prefix.
+func (t *DataType) IndexPrefixRange(fieldName string, prefix string) ([2]string, error) {
+	ifield, err := t.getIndexField(fieldName)
+	if err != nil {
+		return [2]string{}, err
+	}
+	p, err := ifield.FormatPrefix(prefix)
+	if err != nil {
+		return [2]string{}, err
+	}
+	return NewIndexPrefixRange(t.name, ifield.name, p)
+}
+
+// ParseIndexValue converts a field value from the index keys of a field into
+// the indexed value type. Returns false if the value cannot be converted. See
+// the IndexField.Parse method.
+func (t *DataType) ParseIndexValue(fieldName, fvalue string) (interface{}, bool) {
+	ifield, err := t.getIndexField(fieldName)
+	if err != nil {
+		return nil, false
+	}
+	return ifield.Parse(fvalue)
+}
+
+// IndexKeyMap returns the index keys for an object, keyed by the index field
+// (or composite index) name. Multi-valued fields can have more than one index
+// key and all other fields have at most one index key.
+func (t *DataType) IndexKeyMap(ob interface{}) (map[string][]IndexKey, error) {
 	ovalue, ok := t.goodValue(ob)
 	if !ok {
-		return nil, fmt.Errorf("input object is not a struct or pointer to struct of %s type: %w", t.name, os.ErrInvalid)
+		return nil, fmt.Errorf("input object of type %T is not a struct or pointer to struct of %s type: %w", ob, t.name, ErrTypeMismatch)
 	}
 	okey, err := NewObjectKey("/x")
 	if err != nil {
 		return nil, err
 	}
-	ikMap := make(map[string]IndexKey)
+	ikMap := make(map[string][]IndexKey)
 	for _, ifield := range t.indexFields {
-		fstring, err := ifield.ToString(ovalue)
+		// Fields that translate to empty strings (eg: zero values) and fields
+		// behind nil struct pointers are not indexed.
+		fstrings, err := ifield.ToStrings(ovalue)
+		if err != nil {
+			return nil, err
+		}
+		for _, fstring := range fstrings {
+			ik, err := NewIndexKey(okey, t.name, ifield.name, fstring)
+			if err != nil {
+				return nil, err
+			}
+			ikMap[ifield.name] = append(ikMap[ifield.name], ik)
+		}
+	}
+	for _, c := range t.compositeIndexes {
+		cstring, err := c.ToString(ovalue)
 		if err != nil {
 			return nil, err
 		}
-		// Don't index fields that translate to empty strings.
-		if len(fstring) == 0 {
-			return nil, nil
+		if len(cstring) == 0 {
+			continue
 		}
-		ik, err := NewIndexKey(okey, t.name, ifield.name, fstring)
+		ik, err := NewIndexKey(okey, t.name, c.name, cstring)
 		if err != nil {
 			return nil, err
 		}
-		ikMap[ifield.name] = ik
+		ikMap[c.name] = []IndexKey{ik}
+	}
+	if len(t.computedNames) > 0 {
+		if err := t.addComputedKeys(ovalue, okey, ikMap); err != nil {
+			return nil, err
+		}
 	}
 	return ikMap, nil
 }
 
+// addComputedKeys adds index keys for the computed index values of an object
+// to the index key map. Computed index names must be declared by the data
+// type.
+func (t *DataType) addComputedKeys(ovalue reflect.Value, okey ObjectKey, ikMap map[string][]IndexKey) error {
+	pvalue := reflect.New(t.gotype)
+	pvalue.Elem().Set(ovalue)
+	for name, values := range pvalue.Interface().(indexValuer).IndexValues() {
+		if !t.isComputedIndex(name) {
+			return fmt.Errorf("computed index name %s is not declared by the IndexNames method of %s type: %w", name, t.name, os.ErrInvalid)
+		}
+		var fstrings []string
+		for _, v := range values {
+			if len(v) > 0 {
+				fstrings = append(fstrings, toStringNative(reflect.ValueOf(v)))
+			}
+		}
+		for _, fstring := range uniqueStrings(fstrings) {
+			ik, err := NewIndexKey(okey, t.name, name, fstring)
+			if err != nil {
+				return err
+			}
+			ikMap[name] = append(ikMap[name], ik)
+		}
+	}
+	return nil
+}
+
+// Projection returns the serialized form of an object with only the projected
+// fields, which is stored as the value for all index keys of the object.
+// Returns empty string if the data type has no projected fields.
+func (t *DataType) Projection(ob interface{}) (string, error) {
+	ovalue, ok := t.goodValue(ob)
+	if !ok {
+		return "", fmt.Errorf("input object of type %T is not a struct or pointer to struct of %s type: %w", ob, t.name, ErrTypeMismatch)
+	}
+	if len(t.projectFields) == 0 {
+		return "", nil
+	}
+	pvalue := reflect.New(t.gotype)
+	for _, f := range t.projectFields {
+		fvalue, err := f.fieldValue(ovalue)
+		if err != nil {
+			return "", err
+		}
+		if !fvalue.IsValid() || fvalue.IsZero() {
+			continue
+		}
+		// Nested struct pointers in the path are allocated as necessary.
+		dvalue := pvalue.Elem()
+		for i, x := range f.position {
+			if i > 0 && dvalue.Kind() == reflect.Ptr {
+				if dvalue.IsNil() {
+					dvalue.Set(reflect.New(dvalue.Type().Elem()))
+				}
+				dvalue = dvalue.Elem()
+			}
+			dvalue = dvalue.Field(x)
+		}
+		dvalue.Set(fvalue)
+	}
+	return t.marshaler(pvalue.Interface())
+}
+
+// TextKeys returns the full-text index keys for all tokens in the text fields
+// of an object. Returned keys refer to a placeholder object key, similar to
+// the IndexKeyMap.
+func (t *DataType) TextKeys(ob interface{}) ([]IndexKey, error) {
+	ovalue, ok := t.goodValue(ob)
+	if !ok {
+		return nil, fmt.Errorf("input object of type %T is not a struct or pointer to struct of %s type: %w", ob, t.name, ErrTypeMismatch)
+	}
+	okey, err := NewObjectKey("/x")
+	if err != nil {
+		return nil, err
+	}
+	var tks []IndexKey
+	for _, ifield := range t.textFields {
+		tokens, err := ifield.ToTokens(ovalue)
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range tokens {
+			tk, err := NewTextKey(okey, t.name, ifield.name, token)
+			if err != nil {
+				return nil, err
+			}
+			tks = append(tks, tk)
+		}
+	}
+	return tks, nil
+}
+
+// TextQueryRanges returns the full-text keyspace ranges to find objects with
+// all words of the query in a text field. Stop words are ignored in the query
+// if they are not indexed for the field.
+func (t *DataType) TextQueryRanges(fieldName, query string) ([]QueryRange, error) {
+	var tfield *IndexField
+	for _, ifield := range t.textFields {
+		if ifield.name == fieldName {
+			tfield = ifield
+		}
+	}
+	if tfield == nil {
+		return nil, fmt.Errorf("field %s is not a text field of %s type: %w", fieldName, t.name, os.ErrInvalid)
+	}
+	tokens := uniqueStrings(Tokenize(query, tfield.stop))
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("text query has no searchable words: %w", os.ErrInvalid)
+	}
+	var ranges []QueryRange
+	for _, token := range tokens {
+		r, err := NewTextTokenRange(t.name, tfield.name, token)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, QueryRange{Range: r, Exact: true})
+	}
+	return ranges, nil
+}
+
+// QueryRange holds an index keyspace range for a query.
+type QueryRange struct {
+	// Range holds the [begin, end) range of the index keys.
+	Range [2]string
+
+	// Exact when true, indicates that the range holds index keys for a single
+	// index value, so index keys in the range are in the order of their object
+	// keys. Objects are referred at most once in all ranges.
+	Exact bool
+}
+
+// QueryRanges returns the index keyspace ranges to find objects matching the
+// indexed field values of a partial object. Matching objects are referred by
+// an index key in every range.
+//
+// When fields is nil, all indexed fields with non-zero values in the partial
+// object are matched. Otherwise, only the named fields are matched, including
+// their zero values, which requires the fields to be indexed with the zero
+// option.
+//
+// Composite indexes are preferred when values for two or more of their
+// leading fields are available, so that multiple fields are matched with a
+// single index scan. Fields indexed only as composite index members cannot be
+// matched without values for all their preceding fields, which is an error.
+func (t *DataType) QueryRanges(part interface{}, fields []string) ([]QueryRange, error) {
+	ovalue, ok := t.goodValue(part)
+	if !ok {
+		return nil, fmt.Errorf("input object of type %T is not a struct or pointer to struct of %s type: %w", part, t.name, ErrTypeMismatch)
+	}
+	isSet := func(f *IndexField) bool {
+		return f.isSet(ovalue)
+	}
+	if fields != nil {
+		named := make(map[string]bool)
+		for _, name := range fields {
+			named[name] = true
+		}
+		if err := t.checkQueryFields(ovalue, named); err != nil {
+			return nil, err
+		}
+		isSet = func(f *IndexField) bool {
+			return named[f.name]
+		}
+	}
+	indexed := make(map[string]bool)
+	for _, ifield := range t.indexFields {
+		indexed[ifield.name] = true
+	}
+
+	// Pick composite indexes with more matching leading fields first.
+	leading := make([]int, len(t.compositeIndexes))
+	order := make([]int, len(t.compositeIndexes))
+	for i, c := range t.compositeIndexes {
+		order[i] = i
+		for _, f := range c.fields {
+			if !isSet(f) {
+				break
+			}
+			leading[i]++
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return leading[order[i]] > leading[order[j]]
+	})
+
+	var ranges []QueryRange
+	covered := make(map[string]bool)
+	for _, i := range order {
+		c, n := t.compositeIndexes[i], leading[i]
+		if n == 0 || (n == 1 && indexed[c.fields[0].name]) {
+			continue
+		}
+		useful := false
+		for _, f := range c.fields[:n] {
+			if !covered[f.name] {
+				useful = true
+			}
+			covered[f.name] = true
+		}
+		if !useful {
+			continue
+		}
+		cstring, err := c.toStringN(ovalue, n)
+		if err != nil {
+			return nil, err
+		}
+		var r [2]string
+		if n == len(c.fields) {
+			r, err = NewIndexValueRange(t.name, c.name, cstring, cstring)
+		} else {
+			r, err = NewIndexPrefixRange(t.name, c.name, cstring)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, QueryRange{Range: r, Exact: n == len(c.fields)})
+	}
+
+	for _, ifield := range t.indexFields {
+		if covered[ifield.name] || !isSet(ifield) {
+			continue
+		}
+		// Multi-valued fields match when all values are indexed for the object.
+		fstrings, err := ifield.ToStrings(ovalue)
+		if err != nil {
+			return nil, err
+		}
+		for _, fstring := range fstrings {
+			r, err := NewIndexValueRange(t.name, ifield.name, fstring, fstring)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, QueryRange{Range: r, Exact: true})
+		}
+	}
+
+	// Composite index members that are not indexed by themselves can only be
+	// matched along with all their preceding fields.
+	for _, c := range t.compositeIndexes {
+		for _, f := range c.fields {
+			if isSet(f) && !covered[f.name] && !indexed[f.name] {
+				return nil, fmt.Errorf("field %s can only be matched along with the preceding fields of composite index %s: %w", f.name, c.name, os.ErrInvalid)
+			}
+		}
+	}
+	return ranges, nil
+}
+
 func (t *DataType) Marshal(ob interface{}) (string, error) {
 	if _, ok := t.goodValue(ob); !ok {
-		return "", fmt.Errorf("input object is not a struct or pointer to struct of %s type: %w", t.name, os.ErrInvalid)
+		return "", fmt.Errorf("input object of type %T is not a struct or pointer to struct of %s type: %w", ob, t.name, ErrTypeMismatch)
 	}
 	return t.marshaler(ob)
 }
 
 func (t *DataType) Unmarshal(s string, ob interface{}) error {
 	if _, ok := t.goodValue(ob); !ok {
-		return fmt.Errorf("input object is not a struct or pointer to struct of %s type: %w", t.name, os.ErrInvalid)
+		return fmt.Errorf("input object of type %T is not a struct or pointer to struct of %s type: %w", ob, t.name, ErrTypeMismatch)
 	}
 	return t.unmarshaler(s, ob)
 }
 
 func (t *DataType) Clone(ob interface{}) (interface{}, error) {
 	if _, ok := t.goodValue(ob); !ok {
-		return nil, fmt.Errorf("input object is not a struct or pointer to struct of %s type: %w", t.name, os.ErrInvalid)
+		return nil, fmt.Errorf("input object of type %T is not a struct or pointer to struct of %s type: %w", ob, t.name, ErrTypeMismatch)
 	}
 	if t.cloner != nil {
 		return t.cloner(ob)
@@ -123,3 +573,35 @@ func (t *DataType) Clone(ob interface{}) (interface{}, error) {
 	}
 	return tmp, nil
 }
+
+// checkQueryFields verifies that all named fields can be matched with the
+// index in their current values.
+func (t *DataType) checkQueryFields(ovalue reflect.Value, named map[string]bool) error {
+	for name := range named {
+		var members []*IndexField
+		for _, ifield := range t.indexFields {
+			if ifield.name == name {
+				members = append(members, ifield)
+			}
+		}
+		for _, c := range t.compositeIndexes {
+			for _, f := range c.fields {
+				if f.name == name {
+					members = append(members, f)
+				}
+			}
+		}
+		if len(members) == 0 {
+			return fmt.Errorf("field %s is not an indexed field of %s type: %w", name, t.name, os.ErrInvalid)
+		}
+		for _, f := range members {
+			if !f.isPresent(ovalue) {
+				return fmt.Errorf("field %s is behind a nil pointer: %w", name, os.ErrInvalid)
+			}
+			if !f.isSet(ovalue) && !f.zero {
+				return fmt.Errorf("zero values of field %s are not indexed: %w", name, os.ErrInvalid)
+			}
+		}
+	}
+	return nil
+}
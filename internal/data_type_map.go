@@ -61,3 +61,15 @@ func GetDataType(object interface{}) (*DataType, error) {
 	}
 	return t, nil
 }
+
+// GetDataTypeByName returns data type handler for a registered type name.
+func GetDataTypeByName(name string) (*DataType, error) {
+	mapMutex.Lock()
+	defer mapMutex.Unlock()
+
+	t, ok := nameMap[name]
+	if !ok {
+		return nil, fmt.Errorf("type name %s is not registered: %w", name, os.ErrNotExist)
+	}
+	return t, nil
+}
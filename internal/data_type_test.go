@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"testing/quick"
 	"time"
@@ -66,3 +67,210 @@ func TestDataType(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCompositeIndexTags(t *testing.T) {
+	type Composite struct {
+		A int    `kodb:"index=ab,2"`
+		B string `kodb:"index=ab,1"`
+		C bool   `kodb:"index"`
+	}
+	datatype, err := NewDataType("Composite", Composite{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(datatype.compositeIndexes); n != 1 {
+		t.Fatalf("want 1 composite index got %d", n)
+	}
+	if c := datatype.compositeIndexes[0]; c.fields[0].name != "B" || c.fields[1].name != "A" {
+		t.Fatalf("composite index fields must be ordered by the order numbers")
+	}
+	if ranges, err := datatype.QueryRanges(Composite{A: 1, B: "b", C: true}, nil); err != nil {
+		t.Fatal(err)
+	} else if len(ranges) != 2 {
+		t.Fatalf("want 2 index scans got %d", len(ranges))
+	}
+	if ranges, err := datatype.QueryRanges(Composite{B: "b"}, nil); err != nil {
+		t.Fatal(err)
+	} else if len(ranges) != 1 || ranges[0].Exact {
+		t.Fatalf("want 1 composite prefix scan got %v", ranges)
+	}
+	if _, err := datatype.QueryRanges(Composite{A: 1}, nil); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("non-leading composite fields cannot be matched alone, got %v", err)
+	}
+	if _, err := datatype.QueryRanges(Composite{A: 1, C: true}, nil); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("non-leading composite fields must not be dropped, got %v", err)
+	}
+	if _, err := datatype.QueryRanges(Composite{A: 1, C: true}, []string{"C"}); err != nil {
+		t.Fatalf("unnamed composite fields must be ignored: %v", err)
+	}
+
+	type Single struct {
+		A int `kodb:"index=a"`
+	}
+	if _, err := NewDataType("Single", Single{}); err == nil {
+		t.Fatalf("composite indexes with single field must fail")
+	}
+	type Conflict struct {
+		A int `kodb:"index,index=B"`
+		B int `kodb:"index,index=B"`
+	}
+	if _, err := NewDataType("Conflict", Conflict{}); err == nil {
+		t.Fatalf("composite index names must not conflict with field names")
+	}
+}
+
+func TestNestedIndexFields(t *testing.T) {
+	type Inner struct {
+		A int `kodb:"index"`
+	}
+	type Embedded struct {
+		B string `kodb:"index"`
+	}
+	type Outer struct {
+		*Embedded
+
+		X Inner
+		Y *Inner
+		Z *Outer
+	}
+	datatype, err := NewDataType("Outer", Outer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ifield := range datatype.indexFields {
+		names = append(names, ifield.name)
+	}
+	if s := strings.Join(names, ","); s != "B,X.A,Y.A" {
+		t.Fatalf("want B,X.A,Y.A got %s", s)
+	}
+	ikMap, err := datatype.IndexKeyMap(&Outer{Embedded: &Embedded{B: "b"}, X: Inner{A: 1}, Y: &Inner{A: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ikMap) != 3 {
+		t.Fatalf("want 3 index keys got %d", len(ikMap))
+	}
+	if ranges, err := datatype.QueryRanges(&Outer{X: Inner{A: 1}}, nil); err != nil {
+		t.Fatal(err)
+	} else if len(ranges) != 1 {
+		t.Fatalf("want 1 index scan got %d", len(ranges))
+	}
+
+	type Tagged struct {
+		X Inner `kodb:"index"`
+	}
+	if _, err := NewDataType("Tagged", Tagged{}); err == nil {
+		t.Fatalf("struct fields cannot be indexed as a single value")
+	}
+}
+
+func TestNormalizationTags(t *testing.T) {
+	type Normalized struct {
+		A int `kodb:"index,fold"`
+	}
+	if _, err := NewDataType("Normalized", Normalized{}); err == nil {
+		t.Fatalf("normalization options must be rejected for non-string fields")
+	}
+}
+
+func TestUnexportedIndexFields(t *testing.T) {
+	type Unexported struct {
+		created time.Time `kodb:"index"`
+	}
+	if _, err := NewDataType("Unexported", Unexported{}); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("unexported fields must not be indexed, got %v", err)
+	}
+	type Inner struct {
+		count int    `kodb:"index=ab"`
+		Name  string `kodb:"index=ab"`
+	}
+	type Outer struct {
+		Inner Inner
+	}
+	if _, err := NewDataType("Outer", Outer{}); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("unexported nested fields must not be indexed, got %v", err)
+	}
+	type Projected struct {
+		Name  string `kodb:"index"`
+		title string `kodb:"project"`
+	}
+	if _, err := NewDataType("Projected", Projected{}); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("unexported fields must not be projected, got %v", err)
+	}
+}
+
+func TestIndexNameTags(t *testing.T) {
+	type Before struct {
+		Age     int    `kodb:"index,name=age"`
+		Email   string `kodb:"index,unique,name=email"`
+		Address struct {
+			City string `kodb:"index,name=city"`
+		}
+	}
+	type After struct {
+		Years   int    `kodb:"index,name=age"`
+		Mail    string `kodb:"index,unique,name=email"`
+		Address struct {
+			Town string `kodb:"index,name=city"`
+		}
+	}
+	before, err := NewDataType("Person", Before{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := NewDataType("Person", After{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := Before{Age: 10, Email: "x@y"}
+	b.Address.City = "z"
+	a := After{Years: 10, Mail: "x@y"}
+	a.Address.Town = "z"
+
+	bmap, err := before.IndexKeyMap(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	amap, err := after.IndexKeyMap(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(bmap, amap) {
+		t.Fatalf("renamed fields must have same index keys: %v != %v", bmap, amap)
+	}
+	for _, name := range []string{"age", "email", "city"} {
+		if len(amap[name]) != 1 {
+			t.Fatalf("want one index key for %s got %v", name, amap[name])
+		}
+	}
+	if !after.IsUniqueField("email") {
+		t.Fatalf("unique field must be identified by the index name")
+	}
+
+	branges, err := before.QueryRanges(Before{Age: 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aranges, err := after.QueryRanges(After{Years: 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(branges, aranges) {
+		t.Fatalf("renamed fields must have same query ranges: %v != %v", branges, aranges)
+	}
+
+	type Conflict struct {
+		A int `kodb:"index,name=x"`
+		B int `kodb:"index,name=x"`
+	}
+	if _, err := NewDataType("Conflict", Conflict{}); err == nil {
+		t.Fatalf("duplicate index names must be rejected")
+	}
+	type Unindexed struct {
+		A int `kodb:"name=a"`
+	}
+	if _, err := NewDataType("Unindexed", Unindexed{}); err == nil {
+		t.Fatalf("index names for fields without index must be rejected")
+	}
+}
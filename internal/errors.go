@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"os"
+)
+
+// Error is the type for the sentinel errors of the package. Sentinel errors
+// also match the corresponding os package errors with errors.Is function, so
+// that they are compatible with the errors returned before.
+type Error struct {
+	msg  string
+	base error
+}
+
+var (
+	// ErrNotFound is returned when a key or an object doesn't exist. It matches
+	// the os.ErrNotExist error.
+	ErrNotFound = &Error{msg: "not found", base: os.ErrNotExist}
+
+	// ErrTypeMismatch is returned when an object is not of the expected data
+	// type. It matches the os.ErrInvalid error.
+	ErrTypeMismatch = &Error{msg: "type mismatch", base: os.ErrInvalid}
+
+	// ErrInvalidKey is returned for malformed keys. It matches the os.ErrInvalid
+	// error.
+	ErrInvalidKey = &Error{msg: "invalid key", base: os.ErrInvalid}
+
+	// ErrIndexInconsistent is returned when the index and the objects are found
+	// to be out of sync in a way that cannot be repaired automatically.
+	ErrIndexInconsistent = &Error{msg: "index is inconsistent"}
+)
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+// Is returns true if the target is the base error of the sentinel error.
+func (e *Error) Is(target error) bool {
+	return e.base != nil && target == e.base
+}
@@ -20,6 +20,14 @@ func RegisterIndexFieldType(ftype reflect.Type, stringer func(reflect.Value) (st
 	return nil
 }
 
+func hasIndexFieldType(ftype reflect.Type) bool {
+	userDefinedTypesMapMutex.Lock()
+	defer userDefinedTypesMapMutex.Unlock()
+
+	_, ok := userDefinedTypesMap[ftype]
+	return ok
+}
+
 func FormatIndexFieldValue(v reflect.Value) (string, error) {
 	userDefinedTypesMapMutex.Lock()
 	stringer, ok := userDefinedTypesMap[v.Type()]
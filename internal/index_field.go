@@ -1,15 +1,30 @@
 package internal
 
 import (
+	"encoding"
+	"encoding/hex"
 	"fmt"
+	"math"
 	"net"
 	"os"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
 )
 
 const StructTagName = "kodb"
 
+// zeroFieldValue is the index value for zero values that would otherwise
+// translate to empty strings (eg: empty strings). It is same as the empty
+// string with a terminator, so it sorts before all other values.
+const zeroFieldValue = "\x00"
+
 var SupportedKinds = []reflect.Kind{
 	reflect.Bool,
 	reflect.Int,
@@ -22,6 +37,8 @@ var SupportedKinds = []reflect.Kind{
 	reflect.Uint16,
 	reflect.Uint32,
 	reflect.Uint64,
+	reflect.Float32,
+	reflect.Float64,
 	reflect.String,
 }
 var supportedKindsMap = map[reflect.Kind]struct{}{}
@@ -29,9 +46,12 @@ var supportedKindsMap = map[reflect.Kind]struct{}{}
 var SupportedTypes = []reflect.Type{
 	reflect.TypeOf([]byte{}),
 	reflect.TypeOf(net.IP{}),
+	reflect.TypeOf(time.Time{}),
 }
 var supportedTypesMap = map[reflect.Type]struct{}{}
 
+var timeType = reflect.TypeOf(time.Time{})
+
 func init() {
 	for _, v := range SupportedKinds {
 		supportedKindsMap[v] = struct{}{}
@@ -42,82 +62,877 @@ func init() {
 }
 
 type IndexField struct {
-	// Name holds the field name. TODO: How to represent nested field names in
-	// canonical form?
+	// Name holds the index name for the field, which is the Go field name
+	// unless it is chosen explicitly with the "name=value" option. Fields of
+	// nested structs are named with their dotted path from the object (eg:
+	// Address.City) and fields promoted from embedded structs are named same
+	// as their promoted names.
 	name string
 
-	// Position indicates field index in the object. TODO: We should supported
-	// nested field positions in future.
+	// Position indicates the field index sequence from the object, similar to
+	// the reflect.StructField.Index for embedded fields.
 	position []int
 
+	// ftype holds the Go type of the field.
+	ftype reflect.Type
+
+	// ptr when true, indicates that the field is a pointer to the indexed
+	// value. Nil pointers are not indexed and non-nil pointers are indexed
+	// even when they point to zero values.
+	ptr bool
+
+	// multi when true, indicates that the field is a slice, array or a map and
+	// each element (or map key) is indexed separately. vtype holds the type of
+	// indexed values, which is the element (or map key) type for multi-valued
+	// fields and same as the field type for others.
+	multi bool
+	vtype reflect.Type
+
+	// unique when true, indicates that no two objects can have the same value
+	// for the field.
+	unique bool
+
+	// zero when true, indicates that zero values of the field are also indexed.
+	zero bool
+
+	// trim, nfc and fold when true, indicate that string values are trimmed of
+	// surrounding white space, normalized to the Unicode NFC form and case
+	// folded (in that order) before they are indexed or looked up.
+	trim bool
+	nfc  bool
+	fold bool
+
+	// text when true, indicates that the field is a full-text field, which is
+	// indexed by the words in the field value. stop when true, indicates that
+	// common stop words are not indexed for the full-text field.
+	text bool
+	stop bool
+
+	// project when true, indicates that the field value is stored in the values
+	// of all index keys of the object, so that it can be read from the index
+	// without loading the object. Projected fields are not indexed by
+	// themselves.
+	project bool
+
+	// composite if non-empty holds the name of a composite index this field is
+	// part of and order holds the field's position in the composite index.
+	composite string
+	order     int
+
 	// stringer if not-nil holds the user-defined stringer for an index field.
 	stringer func(reflect.Value) (string, error)
 }
 
-func NewIndexFields(sfield reflect.StructField) ([]*IndexField, error) {
+// CompositeIndex holds metadata for an index over multiple fields. Index keys
+// for a composite index hold the tuple of all field values, so that objects
+// can be found by all (or leading) field values with a single index scan.
+type CompositeIndex struct {
+	// name holds the composite index name, which is used in place of the field
+	// name in the index keys.
+	name string
+
+	// fields holds the index fields in the composite index order.
+	fields []*IndexField
+}
+
+// fieldTag holds the parsed struct-tag options for a field.
+type fieldTag struct {
+	index  bool
+	unique bool
+	zero   bool
+
+	trim bool
+	nfc  bool
+	fold bool
+
+	text bool
+	stop bool
+
+	project bool
+
+	// name holds the index name declared with the "name=value" option, which
+	// is used in place of the Go field name in the index keys.
+	name string
+
+	// composites holds the composite index names and optional order numbers
+	// declared with "index=name[,order]" options.
+	composites []string
+	orders     []int
+}
+
+func parseFieldTag(sfield reflect.StructField) (*fieldTag, error) {
+	ftag := new(fieldTag)
 	tag, ok := sfield.Tag.Lookup(StructTagName)
 	if !ok {
-		return nil, nil
+		return ftag, nil
 	}
-	indexed := false
 	tags := strings.Split(tag, ",")
 	for _, t := range tags {
-		if t == "index" {
-			indexed = true
+		switch {
+		case t == "index":
+			ftag.index = true
+		case t == "unique":
+			ftag.unique = true
+		case t == "zero":
+			ftag.zero = true
+		case t == "trim":
+			ftag.trim = true
+		case t == "nfc":
+			ftag.nfc = true
+		case t == "fold":
+			ftag.fold = true
+		case t == "text":
+			ftag.text = true
+		case t == "stop":
+			ftag.stop = true
+		case t == "project":
+			ftag.project = true
+		case strings.HasPrefix(t, "name="):
+			name := strings.TrimPrefix(t, "name=")
+			if len(name) == 0 {
+				return nil, fmt.Errorf("index name for field %s cannot be empty: %w", sfield.Name, os.ErrInvalid)
+			}
+			ftag.name = name
+		case strings.HasPrefix(t, "index="):
+			name := strings.TrimPrefix(t, "index=")
+			if len(name) == 0 {
+				return nil, fmt.Errorf("composite index name for field %s cannot be empty: %w", sfield.Name, os.ErrInvalid)
+			}
+			ftag.composites = append(ftag.composites, name)
+			ftag.orders = append(ftag.orders, -1)
+		default:
+			// An order number applies to the preceding composite index option.
+			if n, err := strconv.Atoi(t); err == nil && len(ftag.composites) > 0 {
+				if n < 0 {
+					return nil, fmt.Errorf("composite index order for field %s cannot be negative: %w", sfield.Name, os.ErrInvalid)
+				}
+				ftag.orders[len(ftag.orders)-1] = n
+			}
 		}
 	}
-	if !indexed {
+	if ftag.unique && !ftag.index {
+		return nil, fmt.Errorf("unique field %s must also be indexed: %w", sfield.Name, os.ErrInvalid)
+	}
+	if ftag.stop && !ftag.text {
+		return nil, fmt.Errorf("stop option for field %s is only valid for text fields: %w", sfield.Name, os.ErrInvalid)
+	}
+	if len(ftag.name) > 0 && !ftag.indexed() {
+		return nil, fmt.Errorf("name option for field %s is only valid for indexed fields: %w", sfield.Name, os.ErrInvalid)
+	}
+	return ftag, nil
+}
+
+// indexed returns true if the field is indexed in any form.
+func (ftag *fieldTag) indexed() bool {
+	return ftag.index || ftag.text || len(ftag.composites) > 0
+}
+
+// NewIndexFields returns the index field metadata for a struct field. Fields
+// that are part of composite indexes return one index field for each
+// composite index and full-text fields return a separate index field for the
+// full-text index.
+func NewIndexFields(sfield reflect.StructField) ([]*IndexField, error) {
+	ftag, err := parseFieldTag(sfield)
+	if err != nil {
+		return nil, err
+	}
+	// Values of unexported fields cannot be read through the reflection and
+	// they are not serialized either.
+	if ftag.indexed() && len(sfield.PkgPath) > 0 {
+		return nil, fmt.Errorf("unexported field %s cannot be indexed: %w", sfield.Name, os.ErrInvalid)
+	}
+	if ftag.project && len(sfield.PkgPath) > 0 {
+		return nil, fmt.Errorf("unexported field %s cannot be projected: %w", sfield.Name, os.ErrInvalid)
+	}
+	var ifields []*IndexField
+	if ftag.project {
+		ifield := &IndexField{
+			name:     sfield.Name,
+			position: append([]int{}, sfield.Index...),
+			ftype:    sfield.Type,
+			project:  true,
+		}
+		ifields = append(ifields, ifield)
+	}
+	if !ftag.indexed() {
+		return ifields, nil
+	}
+	// Pointer fields index the values they point to, unless the pointer type
+	// itself has an user-defined string conversion.
+	ftype, ptr := sfield.Type, false
+	if ftype.Kind() == reflect.Ptr && !hasIndexFieldType(ftype) {
+		ftype, ptr = ftype.Elem(), true
+		if ftype.Kind() == reflect.Ptr {
+			return nil, fmt.Errorf("pointer to pointer field %s cannot be indexed: %w", sfield.Name, os.ErrInvalid)
+		}
+	}
+	if isFlattenable(ftype) && !hasTextFormat(ftype) {
+		return nil, fmt.Errorf("could not flatten field %s, index its member fields instead: %w", sfield.Name, os.ErrInvalid)
+	}
+	multi, vtype := isMultiValued(ftype), ftype
+	if multi {
+		if ptr {
+			return nil, fmt.Errorf("pointer to multi-valued field %s cannot be indexed: %w", sfield.Name, os.ErrInvalid)
+		}
+		if ftag.zero {
+			return nil, fmt.Errorf("zero values of multi-valued field %s cannot be indexed: %w", sfield.Name, os.ErrInvalid)
+		}
+		if len(ftag.composites) > 0 {
+			return nil, fmt.Errorf("multi-valued field %s cannot be part of a composite index: %w", sfield.Name, os.ErrInvalid)
+		}
+		if vtype = ftype.Elem(); ftype.Kind() == reflect.Map {
+			vtype = ftype.Key()
+		}
+		if isStruct(vtype) || isStructPtr(vtype) {
+			return nil, fmt.Errorf("could not flatten elements of field %s: %w", sfield.Name, os.ErrInvalid)
+		}
+	}
+	if (ftag.trim || ftag.nfc || ftag.fold) && vtype.Kind() != reflect.String {
+		return nil, fmt.Errorf("string normalization options are not valid for field %s: %w", sfield.Name, os.ErrInvalid)
+	}
+	if ftag.text && vtype.Kind() != reflect.String {
+		return nil, fmt.Errorf("non-string field %s cannot be a text field: %w", sfield.Name, os.ErrInvalid)
+	}
+	// Index names are stored in the index keys, so they can be chosen
+	// independent of the Go field names.
+	iname := sfield.Name
+	if len(ftag.name) > 0 {
+		iname = ftag.name
+	}
+	if ftag.index {
+		ifield := &IndexField{
+			name:     iname,
+			position: append([]int{}, sfield.Index...),
+			ftype:    sfield.Type,
+			ptr:      ptr,
+			multi:    multi,
+			vtype:    vtype,
+			unique:   ftag.unique,
+			zero:     ftag.zero || ptr,
+			trim:     ftag.trim,
+			nfc:      ftag.nfc,
+			fold:     ftag.fold,
+		}
+		ifields = append(ifields, ifield)
+	}
+	for i, name := range ftag.composites {
+		ifield := &IndexField{
+			name:      iname,
+			position:  append([]int{}, sfield.Index...),
+			ftype:     sfield.Type,
+			ptr:       ptr,
+			vtype:     vtype,
+			zero:      ftag.zero || ptr,
+			trim:      ftag.trim,
+			nfc:       ftag.nfc,
+			fold:      ftag.fold,
+			composite: name,
+			order:     ftag.orders[i],
+		}
+		ifields = append(ifields, ifield)
+	}
+	if ftag.text {
+		ifield := &IndexField{
+			name:     iname,
+			position: append([]int{}, sfield.Index...),
+			ftype:    sfield.Type,
+			ptr:      ptr,
+			multi:    multi,
+			vtype:    vtype,
+			text:     true,
+			stop:     ftag.stop,
+		}
+		ifields = append(ifields, ifield)
+	}
+	return ifields, nil
+}
+
+// NewStructIndexFields returns index fields for all fields of a struct type,
+// including the fields of nested and embedded structs.
+func NewStructIndexFields(stype reflect.Type) ([]*IndexField, error) {
+	ifields, err := flattenIndexFields(stype, "", nil, make(map[reflect.Type]bool))
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, ifield := range ifields {
+		if len(ifield.composite) > 0 || ifield.text || ifield.project {
+			continue
+		}
+		if names[ifield.name] {
+			return nil, fmt.Errorf("index field name %s is ambiguous: %w", ifield.name, os.ErrInvalid)
+		}
+		names[ifield.name] = true
+	}
+	return ifields, nil
+}
+
+func flattenIndexFields(stype reflect.Type, prefix string, position []int, visiting map[reflect.Type]bool) ([]*IndexField, error) {
+	// Recursive types could lead to infinite nesting.
+	if visiting[stype] {
 		return nil, nil
 	}
-	// TODO: Add support to flatten struct members.
-	if isStruct(sfield.Type) || isStructPtr(sfield.Type) {
-		return nil, fmt.Errorf("could not flatten field %s: %w", sfield.Name, os.ErrInvalid)
+	visiting[stype] = true
+	defer delete(visiting, stype)
+
+	var ifields []*IndexField
+	for i := 0; i < stype.NumField(); i++ {
+		sfield := stype.Field(i)
+		sfield.Index = append(append([]int{}, position...), i)
+		sfield.Name = prefix + sfield.Name
+		if isFlattenable(sfield.Type) {
+			ftag, err := parseFieldTag(sfield)
+			if err != nil {
+				return nil, err
+			}
+			if !ftag.indexed() && !ftag.project {
+				// Unexported fields are not serialized, so they are not indexed.
+				if len(sfield.PkgPath) > 0 {
+					continue
+				}
+				nprefix := sfield.Name + "."
+				if sfield.Anonymous {
+					nprefix = prefix
+				}
+				ntype := sfield.Type
+				if isStructPtr(ntype) {
+					ntype = ntype.Elem()
+				}
+				nfields, err := flattenIndexFields(ntype, nprefix, sfield.Index, visiting)
+				if err != nil {
+					return nil, err
+				}
+				ifields = append(ifields, nfields...)
+				continue
+			}
+		}
+		nfields, err := NewIndexFields(sfield)
+		if err != nil {
+			return nil, err
+		}
+		ifields = append(ifields, nfields...)
+	}
+	return ifields, nil
+}
+
+// isFlattenable returns true if the type is a struct or a pointer to struct
+// that is not indexed as a single value.
+func isFlattenable(ftype reflect.Type) bool {
+	if isStructPtr(ftype) {
+		ftype = ftype.Elem()
+	}
+	if !isStruct(ftype) {
+		return false
+	}
+	if _, ok := supportedTypesMap[ftype]; ok {
+		return false
+	}
+	return !hasIndexFieldType(ftype)
+}
+
+// isMultiValued returns true if values of the type are indexed as multiple
+// values. Slices, arrays and maps are multi-valued unless they have a standard,
+// an user-defined or a text string conversion (eg: []byte, net.IP) or they
+// are byte arrays (eg: [32]byte hashes).
+func isMultiValued(ftype reflect.Type) bool {
+	switch ftype.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+	default:
+		return false
+	}
+	if _, ok := supportedTypesMap[ftype]; ok {
+		return false
+	}
+	if isByteArray(ftype) {
+		return false
+	}
+	return !hasIndexFieldType(ftype) && !hasTextFormat(ftype)
+}
+
+// isByteArray returns true if the type is an array of bytes, which is indexed
+// as a single value in the hexadecimal form, similar to the byte slices.
+func isByteArray(ftype reflect.Type) bool {
+	return ftype.Kind() == reflect.Array && ftype.Elem().Kind() == reflect.Uint8
+}
+
+var (
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// hasTextFormat returns true if the type or a pointer to the type implements
+// the encoding.TextMarshaler or fmt.Stringer interfaces.
+func hasTextFormat(ftype reflect.Type) bool {
+	ptype := reflect.PtrTo(ftype)
+	return ptype.Implements(textMarshalerType) || ptype.Implements(stringerType)
+}
+
+// toStringText converts a value into string using the encoding.TextMarshaler
+// or the fmt.Stringer interface, in that order, when implemented by the value
+// or a pointer to the value.
+func toStringText(v reflect.Value) (string, bool, error) {
+	if !hasTextFormat(v.Type()) {
+		return "", false, nil
+	}
+	// Method set of the pointer includes the methods of the value.
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	switch x := p.Interface().(type) {
+	case encoding.TextMarshaler:
+		text, err := x.MarshalText()
+		if err != nil {
+			return "", true, err
+		}
+		return string(text), true, nil
+	case fmt.Stringer:
+		return x.String(), true, nil
 	}
-	ifield := &IndexField{
-		name:     sfield.Name,
-		position: append([]int{}, sfield.Index...),
+	return "", false, nil
+}
+
+// NewCompositeIndexes groups the composite index members into composite
+// indexes. Fields without an explicit order number are placed in the struct
+// field order after the fields with an order number.
+func NewCompositeIndexes(members []*IndexField) ([]*CompositeIndex, error) {
+	var cindexes []*CompositeIndex
+	cmap := make(map[string]*CompositeIndex)
+	for _, m := range members {
+		c, ok := cmap[m.composite]
+		if !ok {
+			c = &CompositeIndex{name: m.composite}
+			cmap[m.composite] = c
+			cindexes = append(cindexes, c)
+		}
+		c.fields = append(c.fields, m)
+	}
+	for _, c := range cindexes {
+		if len(c.fields) < 2 {
+			return nil, fmt.Errorf("composite index %s must have at least two fields: %w", c.name, os.ErrInvalid)
+		}
+		sort.SliceStable(c.fields, func(i, j int) bool {
+			a, b := c.fields[i].order, c.fields[j].order
+			if a == -1 || b == -1 {
+				return a != -1 && b == -1
+			}
+			return a < b
+		})
+		for i := 1; i < len(c.fields); i++ {
+			if o := c.fields[i].order; o != -1 && o == c.fields[i-1].order {
+				return nil, fmt.Errorf("composite index %s has duplicate order numbers: %w", c.name, os.ErrInvalid)
+			}
+		}
 	}
-	return []*IndexField{ifield}, nil
+	return cindexes, nil
 }
 
+// ToString returns the tuple of all field values for the composite index. An
+// empty string is returned if any of the fields translate to empty strings.
+func (c *CompositeIndex) ToString(ovalue reflect.Value) (string, error) {
+	return c.toStringN(ovalue, len(c.fields))
+}
+
+// toStringN returns the tuple of first n field values for the composite index.
+//
+// Tuples are formed by concatenating the field values, which preserves the
+// order of tuples when field values are prefix-free. Values of native kinds
+// are prefix-free (fixed-width or terminated) and other values are terminated
+// with a zero byte.
+func (c *CompositeIndex) toStringN(ovalue reflect.Value, n int) (string, error) {
+	var sb strings.Builder
+	for _, f := range c.fields[:n] {
+		fstring, err := f.ToString(ovalue)
+		if err != nil {
+			return "", err
+		}
+		if len(fstring) == 0 {
+			return "", nil
+		}
+		sb.WriteString(fstring)
+		if !f.isNative() {
+			sb.WriteByte(0)
+		}
+	}
+	return sb.String(), nil
+}
+
+// ToString returns the index value for the field. Empty string is returned
+// when the field should not be indexed, i.e., for zero values of fields
+// without the zero option.
 func (f *IndexField) ToString(ovalue reflect.Value) (string, error) {
+	fvalue, err := f.fieldValue(ovalue)
+	if err != nil || !fvalue.IsValid() {
+		return "", err
+	}
+	if f.multi {
+		return "", fmt.Errorf("multi-valued field %s cannot be converted to a string: %w", f.name, os.ErrInvalid)
+	}
+	if fvalue.IsZero() && !f.zero {
+		return "", nil
+	}
+	return f.formatZero(fvalue)
+}
+
+// ToStrings returns all non-empty index values for the field. Multi-valued
+// fields can have zero or more values and other fields can have zero or one
+// value.
+func (f *IndexField) ToStrings(ovalue reflect.Value) ([]string, error) {
+	if !f.multi {
+		fstring, err := f.ToString(ovalue)
+		if err != nil || len(fstring) == 0 {
+			return nil, err
+		}
+		return []string{fstring}, nil
+	}
+	fvalue, err := f.fieldValue(ovalue)
+	if err != nil || !fvalue.IsValid() {
+		return nil, err
+	}
+	var values []reflect.Value
+	switch {
+	case fvalue.Kind() == reflect.Map:
+		values = fvalue.MapKeys()
+	default:
+		for i := 0; i < fvalue.Len(); i++ {
+			values = append(values, fvalue.Index(i))
+		}
+	}
+	var fstrings []string
+	seen := make(map[string]struct{})
+	for _, v := range values {
+		fstring, err := f.Format(v)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[fstring]; ok || len(fstring) == 0 {
+			continue
+		}
+		seen[fstring] = struct{}{}
+		fstrings = append(fstrings, fstring)
+	}
+	sort.Strings(fstrings)
+	return fstrings, nil
+}
+
+// fieldValue returns the field value from the object. Returns an invalid
+// value without an error when a nested struct pointer in the field's path is
+// nil.
+func (f *IndexField) fieldValue(ovalue reflect.Value) (reflect.Value, error) {
 	if !isStructValue(ovalue) {
-		return "", fmt.Errorf("input value must be a struct: %w", os.ErrInvalid)
+		return reflect.Value{}, fmt.Errorf("input value must be a struct: %w", os.ErrInvalid)
+	}
+	fvalue := ovalue
+	for i, x := range f.position {
+		if i > 0 && fvalue.Kind() == reflect.Ptr {
+			if fvalue.IsNil() {
+				return reflect.Value{}, nil
+			}
+			fvalue = fvalue.Elem()
+		}
+		fvalue = fvalue.Field(x)
 	}
-	fvalue := ovalue.FieldByIndex(f.position)
 	if !fvalue.IsValid() {
-		return "", fmt.Errorf("couldn't get index field value for %s: %w", f.name, os.ErrInvalid)
+		return reflect.Value{}, fmt.Errorf("couldn't get index field value for %s: %w", f.name, os.ErrInvalid)
+	}
+	if f.ptr {
+		if fvalue.IsNil() {
+			return reflect.Value{}, nil
+		}
+		fvalue = fvalue.Elem()
+	}
+	return fvalue, nil
+}
+
+// isPresent returns true if the field is reachable in the object, i.e., no
+// nested struct pointer in the field's path is nil.
+func (f *IndexField) isPresent(ovalue reflect.Value) bool {
+	fvalue, err := f.fieldValue(ovalue)
+	return err == nil && fvalue.IsValid()
+}
+
+// isSet returns true if the field value is present and is not a zero value in
+// the object. Non-nil pointer fields are always set.
+func (f *IndexField) isSet(ovalue reflect.Value) bool {
+	fvalue, err := f.fieldValue(ovalue)
+	if err != nil || !fvalue.IsValid() {
+		return false
+	}
+	return f.ptr || !fvalue.IsZero()
+}
+
+// Format converts an indexed value into it's index key form. Values of the
+// supported kinds are converted into an order-preserving form, so that index
+// keys are sorted in the order of field values. For multi-valued fields, input
+// must be an element (or a map key) of the field.
+func (f *IndexField) Format(fvalue reflect.Value) (string, error) {
+	if fvalue.Type() != f.vtype {
+		return "", fmt.Errorf("value of type %s is not valid for index field %s: %w", fvalue.Type(), f.name, os.ErrInvalid)
+	}
+
+	if f.trim || f.nfc || f.fold {
+		s := f.normalize(fvalue.String())
+		fvalue = reflect.ValueOf(s).Convert(f.vtype)
 	}
 
+	// User-defined conversions override the standard and native conversions,
+	// which in turn are preferred over the encoding.TextMarshaler and
+	// fmt.Stringer interfaces, so that values of native kinds are always
+	// indexed in an order-preserving form.
 	if f.stringer != nil {
-		return f.stringer(ovalue)
+		return f.stringer(fvalue)
 	}
 
-	if _, ok := supportedKindsMap[fvalue.Kind()]; ok {
-		return toStringNative(fvalue.Interface()), nil
+	if hasIndexFieldType(fvalue.Type()) {
+		return FormatIndexFieldValue(fvalue)
 	}
 
 	if _, ok := supportedTypesMap[fvalue.Type()]; ok {
 		return toStringStandard(fvalue.Interface())
 	}
 
-	return FormatIndexFieldValue(fvalue)
+	if _, ok := supportedKindsMap[fvalue.Kind()]; ok {
+		return toStringNative(fvalue), nil
+	}
+
+	if s, ok, err := toStringText(fvalue); ok {
+		return s, err
+	}
+
+	if isByteArray(fvalue.Type()) {
+		bs := make([]byte, fvalue.Len())
+		reflect.Copy(reflect.ValueOf(bs), fvalue)
+		return hex.EncodeToString(bs), nil
+	}
+
+	return "", fmt.Errorf("values of type %s in index field %s cannot be converted to strings: %w", fvalue.Type(), f.name, os.ErrInvalid)
 }
 
-func toStringNative(v interface{}) string {
-	switch x := v.(type) {
-	case bool:
-		if x {
+// isNative returns true if field values are indexed in their native form,
+// which is prefix-free.
+func (f *IndexField) isNative() bool {
+	if f.stringer != nil || hasIndexFieldType(f.vtype) {
+		return false
+	}
+	if f.vtype == timeType {
+		return true
+	}
+	if _, ok := supportedTypesMap[f.vtype]; ok {
+		return false
+	}
+	// Byte arrays are fixed-width in the hexadecimal form.
+	if isByteArray(f.vtype) && !hasTextFormat(f.vtype) {
+		return true
+	}
+	_, ok := supportedKindsMap[f.vtype.Kind()]
+	return ok
+}
+
+// formatZero is similar to Format, but also converts zero values of the
+// fields with zero option into a non-empty string, that sorts before all other
+// values.
+func (f *IndexField) formatZero(fvalue reflect.Value) (string, error) {
+	fstring, err := f.Format(fvalue)
+	if err != nil {
+		return "", err
+	}
+	if len(fstring) == 0 && f.zero && fvalue.IsZero() {
+		return zeroFieldValue, nil
+	}
+	return fstring, nil
+}
+
+// FormatInterface is similar to Format, but also converts the input value to
+// the indexed value type when possible. For example, an untyped integer
+// constant can be used as the value for a int8 or uint64 index field. Numbers
+// outside the range of the indexed value type are rejected.
+func (f *IndexField) FormatInterface(v interface{}) (string, error) {
+	fvalue := reflect.ValueOf(v)
+	if !fvalue.IsValid() {
+		return "", fmt.Errorf("nil value is not valid for index field %s: %w", f.name, os.ErrInvalid)
+	}
+	if fvalue.Type() != f.vtype {
+		if !isConvertible(fvalue.Type(), f.vtype) {
+			return "", fmt.Errorf("value of type %s is not valid for index field %s: %w", fvalue.Type(), f.name, os.ErrInvalid)
+		}
+		if checkRange(fvalue, f.vtype) != 0 {
+			return "", fmt.Errorf("value %v is out of range for index field %s of type %s: %w", v, f.name, f.vtype, os.ErrInvalid)
+		}
+		fvalue = fvalue.Convert(f.vtype)
+	}
+	return f.formatZero(fvalue)
+}
+
+// checkBound compares a range boundary value with the range of the indexed
+// value type. See the checkRange function.
+func (f *IndexField) checkBound(v interface{}) int {
+	fvalue := reflect.ValueOf(v)
+	if !fvalue.IsValid() || fvalue.Type() == f.vtype || !isConvertible(fvalue.Type(), f.vtype) {
+		return 0
+	}
+	return checkRange(fvalue, f.vtype)
+}
+
+// valueRange returns the index keyspace range for the field values between lo
+// and hi. A nil lo or hi value leaves the range unbounded on that side and
+// the open flags exclude the boundary values from the range. Boundaries
+// outside the range of the indexed value type are clamped to the type's
+// range, so that, for example, values above -1 include all values of an
+// unsigned field. Boundary values that format to empty strings (eg: empty
+// strings of the fields without the zero option) are never indexed, so they
+// sort before all index values.
+func (f *IndexField) valueRange(typeName string, lo, hi interface{}, loOpen, hiOpen bool) ([2]string, error) {
+	empty := false
+	switch f.checkBound(lo) {
+	case -1:
+		lo = nil
+	case 1:
+		lo, empty = nil, true
+	}
+	switch f.checkBound(hi) {
+	case 1:
+		hi = nil
+	case -1:
+		hi, empty = nil, true
+	}
+
+	var los, his string
+	var err error
+	if lo != nil {
+		if los, err = f.FormatInterface(lo); err != nil {
+			return [2]string{}, err
+		}
+	}
+	if hi != nil {
+		if his, err = f.FormatInterface(hi); err != nil {
+			return [2]string{}, err
+		}
+		if len(his) == 0 {
+			hi, empty = nil, true
+		}
+	}
+	r, err := NewIndexValueRange(typeName, f.name, los, his)
+	if err != nil {
+		return [2]string{}, err
+	}
+	if empty {
+		return [2]string{r[0], r[0]}, nil
+	}
+	if lo != nil && loOpen && len(los) > 0 {
+		x, err := NewIndexValueRange(typeName, f.name, los, los)
+		if err != nil {
+			return [2]string{}, err
+		}
+		r[0] = x[1]
+	}
+	if hi != nil && hiOpen {
+		x, err := NewIndexValueRange(typeName, f.name, his, his)
+		if err != nil {
+			return [2]string{}, err
+		}
+		r[1] = x[0]
+	}
+	return r, nil
+}
+
+// FormatPrefix converts a string prefix into a prefix of the index values.
+// Only the fields with string values in their native form support prefixes.
+func (f *IndexField) FormatPrefix(prefix string) (string, error) {
+	if f.vtype.Kind() != reflect.String || hasIndexFieldType(f.vtype) {
+		return "", fmt.Errorf("index field %s doesn't support prefixes: %w", f.name, os.ErrInvalid)
+	}
+	// Trailing white space is significant in a prefix.
+	if f.trim {
+		prefix = strings.TrimLeftFunc(prefix, unicode.IsSpace)
+	}
+	if f.nfc {
+		prefix = norm.NFC.String(prefix)
+	}
+	if f.fold {
+		prefix = cases.Fold().String(prefix)
+	}
+	// Non-empty strings are not modified other than adding a terminator, so a
+	// prefix of the string is also a prefix of the index value.
+	return prefix, nil
+}
+
+// normalize applies the string normalization options of the field.
+func (f *IndexField) normalize(s string) string {
+	if f.trim {
+		s = strings.TrimSpace(s)
+	}
+	if f.nfc {
+		s = norm.NFC.String(s)
+	}
+	if f.fold {
+		s = cases.Fold().String(s)
+	}
+	return s
+}
+
+// ToTokens returns all words in the full-text field value.
+func (f *IndexField) ToTokens(ovalue reflect.Value) ([]string, error) {
+	fvalue, err := f.fieldValue(ovalue)
+	if err != nil || !fvalue.IsValid() {
+		return nil, err
+	}
+	var texts []string
+	switch {
+	case !f.multi:
+		texts = []string{fvalue.String()}
+	case fvalue.Kind() == reflect.Map:
+		for _, k := range fvalue.MapKeys() {
+			texts = append(texts, k.String())
+		}
+	default:
+		for i := 0; i < fvalue.Len(); i++ {
+			texts = append(texts, fvalue.Index(i).String())
+		}
+	}
+	var tokens []string
+	for _, text := range texts {
+		tokens = append(tokens, Tokenize(text, f.stop)...)
+	}
+	return uniqueStrings(tokens), nil
+}
+
+// toStringNative converts values of the supported kinds into strings that
+// preserve the ordering of the values. Integers are encoded as fixed width
+// hexadecimal numbers with the sign bit flipped for signed integers. Non-empty
+// strings are terminated by a zero byte, so that a string sorts before all
+// other strings it is a prefix of, even after it's escaped in the index key.
+//
+// Note that strings with embedded zero bytes may not preserve the order.
+func toStringNative(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
 			return "true"
 		} else {
 			return "false"
 		}
-	case int, int8, int16, int32, int64:
-		return fmt.Sprintf("%d", v)
-	case uint, uint8, uint16, uint32, uint64:
-		return fmt.Sprintf("%d", v)
-	case string:
-		return x
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("%016x", uint64(v.Int())^(1<<63))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprintf("%016x", v.Uint())
+	case reflect.Float32, reflect.Float64:
+		// Positive numbers are ordered by their IEEE bits with the sign bit set
+		// and negative numbers are ordered by their inverted IEEE bits. Negative
+		// zero is same as the positive zero.
+		x := v.Float()
+		if x == 0 {
+			x = 0
+		}
+		bits := math.Float64bits(x)
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		return fmt.Sprintf("%016x", bits)
+	case reflect.String:
+		if s := v.String(); len(s) > 0 {
+			return s + "\x00"
+		}
+		return ""
 	}
 	return "unsupported-index-field-kind"
 }
@@ -128,6 +943,99 @@ func toStringStandard(v interface{}) (string, error) {
 		return fmt.Sprintf("%x", x), nil
 	case net.IP:
 		return x.String(), nil
+	case time.Time:
+		// Seconds since epoch are encoded similar to the signed integers and
+		// followed by the fixed-width nanoseconds, so that time values sort in
+		// the chronological order irrespective of their locations.
+		return fmt.Sprintf("%016x%08x", uint64(x.Unix())^(1<<63), x.Nanosecond()), nil
 	}
 	return "unsupported-index-field-type", os.ErrInvalid
 }
+
+// Parse converts an index value back into the indexed value type, which is
+// the reverse of Format for values in their native form and the standard
+// types. Returns false if the value cannot be converted, which is the case
+// for values converted with the user-defined conversions and the
+// encoding.TextMarshaler or fmt.Stringer interfaces. Note that string values
+// are returned in their normalized form and time values are returned in UTC.
+func (f *IndexField) Parse(s string) (interface{}, bool) {
+	if f.stringer != nil || hasIndexFieldType(f.vtype) {
+		return nil, false
+	}
+	if s == zeroFieldValue && f.zero {
+		return reflect.Zero(f.vtype).Interface(), true
+	}
+	if _, ok := supportedTypesMap[f.vtype]; ok {
+		switch f.vtype {
+		case timeType:
+			if len(s) != 24 {
+				return nil, false
+			}
+			secs, err := strconv.ParseUint(s[:16], 16, 64)
+			if err != nil {
+				return nil, false
+			}
+			nsecs, err := strconv.ParseUint(s[16:], 16, 32)
+			if err != nil {
+				return nil, false
+			}
+			return time.Unix(int64(secs^(1<<63)), int64(nsecs)).UTC(), true
+		case reflect.TypeOf(net.IP{}):
+			ip := net.ParseIP(s)
+			return ip, ip != nil
+		default:
+			bs, err := hex.DecodeString(s)
+			return bs, err == nil
+		}
+	}
+	if isByteArray(f.vtype) && !hasTextFormat(f.vtype) {
+		bs, err := hex.DecodeString(s)
+		if err != nil || len(bs) != f.vtype.Len() {
+			return nil, false
+		}
+		fvalue := reflect.New(f.vtype).Elem()
+		reflect.Copy(fvalue, reflect.ValueOf(bs))
+		return fvalue.Interface(), true
+	}
+	if _, ok := supportedKindsMap[f.vtype.Kind()]; !ok {
+		return nil, false
+	}
+
+	fvalue := reflect.New(f.vtype).Elem()
+	switch fvalue.Kind() {
+	case reflect.Bool:
+		if s != "true" && s != "false" {
+			return nil, false
+		}
+		fvalue.SetBool(s == "true")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := strconv.ParseUint(s, 16, 64)
+		if err != nil {
+			return nil, false
+		}
+		fvalue.SetInt(int64(x ^ (1 << 63)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, err := strconv.ParseUint(s, 16, 64)
+		if err != nil {
+			return nil, false
+		}
+		fvalue.SetUint(x)
+	case reflect.Float32, reflect.Float64:
+		bits, err := strconv.ParseUint(s, 16, 64)
+		if err != nil {
+			return nil, false
+		}
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		fvalue.SetFloat(math.Float64frombits(bits))
+	case reflect.String:
+		if !strings.HasSuffix(s, "\x00") {
+			return nil, false
+		}
+		fvalue.SetString(strings.TrimSuffix(s, "\x00"))
+	}
+	return fvalue.Interface(), true
+}
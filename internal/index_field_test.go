@@ -0,0 +1,275 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+func TestIndexFieldOrder(t *testing.T) {
+	type OrderType struct {
+		Int    int64     `kodb:"index"`
+		Uint   uint32    `kodb:"index"`
+		String string    `kodb:"index"`
+		Float  float64   `kodb:"index"`
+		Time   time.Time `kodb:"index"`
+	}
+	datatype, err := NewDataType("OrderType", OrderType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := func(field string, v interface{}) string {
+		ifield, err := datatype.getIndexField(field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := ifield.FormatInterface(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return escapeFieldValue(s)
+	}
+
+	ints := func(a, b int64) bool {
+		x, y := format("Int", a), format("Int", b)
+		return (a < b) == (x < y) && (a == b) == (x == y)
+	}
+	if err := quick.Check(ints, nil); err != nil {
+		t.Fatal(err)
+	}
+	uints := func(a, b uint32) bool {
+		x, y := format("Uint", a), format("Uint", b)
+		return (a < b) == (x < y) && (a == b) == (x == y)
+	}
+	if err := quick.Check(uints, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Escaped string values are followed by the '/' separator in the index keys.
+	strs := func(a, b string) bool {
+		if len(a) == 0 || len(b) == 0 || strings.ContainsRune(a+b, 0) {
+			return true
+		}
+		x, y := format("String", a)+"/", format("String", b)+"/"
+		return (a < b) == (x < y) && (a == b) == (x == y)
+	}
+	if err := quick.Check(strs, nil); err != nil {
+		t.Fatal(err)
+	}
+	floats := func(a, b float64) bool {
+		x, y := format("Float", a), format("Float", b)
+		return (a < b) == (x < y) && (a == b) == (x == y)
+	}
+	if err := quick.Check(floats, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !floats(-1.5, 0) {
+		t.Fatalf("negative floats must sort before zero")
+	}
+	if !floats(math.Copysign(0, -1), 0) {
+		t.Fatalf("negative zero must be same as the zero")
+	}
+	times := func(a, b int64, an, bn uint32, east bool) bool {
+		zone := time.UTC
+		if east {
+			zone = time.FixedZone("east", 5*3600)
+		}
+		ta := time.Unix(a>>1, int64(an%1e9)).In(zone)
+		tb := time.Unix(b>>1, int64(bn%1e9))
+		x, y := format("Time", ta), format("Time", tb)
+		return ta.Before(tb) == (x < y) && ta.Equal(tb) == (x == y)
+	}
+	if err := quick.Check(times, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := format("Int", 10); s != format("Int", int8(10)) {
+		t.Fatalf("integer constants must be converted to the field type")
+	}
+	ifield, _ := datatype.getIndexField("String")
+	if _, err := ifield.FormatInterface(10); err == nil {
+		t.Fatalf("integers must not be converted to strings")
+	}
+	if _, err := ifield.Format(reflect.ValueOf(10)); err == nil {
+		t.Fatalf("values of other types must be rejected")
+	}
+	if s := format("Float", 10); s != format("Float", 10.0) {
+		t.Fatalf("integer constants must be converted to floats")
+	}
+	ifield, _ = datatype.getIndexField("Int")
+	if _, err := ifield.FormatInterface(1.5); err == nil {
+		t.Fatalf("floats must not be converted to integers")
+	}
+	if _, err := ifield.FormatInterface(uint64(math.MaxUint64)); !errors.Is(err, os.ErrInvalid) {
+		t.Fatalf("integers out of the field type's range must be rejected")
+	}
+	ifield, _ = datatype.getIndexField("Uint")
+	for _, v := range []interface{}{-1, 1 << 32, uint64(1 << 40)} {
+		if _, err := ifield.FormatInterface(v); !errors.Is(err, os.ErrInvalid) {
+			t.Fatalf("value %v out of the field type's range must be rejected, got %v", v, err)
+		}
+	}
+	if s := format("Uint", 1<<32-1); s != format("Uint", uint32(math.MaxUint32)) {
+		t.Fatalf("largest value of the field type must be accepted")
+	}
+
+	// Range boundaries out of the field type's range are clamped.
+	r, err := datatype.IndexValueRange("Uint", -1, 1<<40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all, _ := datatype.IndexValueRange("Uint", nil, nil); r != all {
+		t.Fatalf("want range %q for all values, got %q", all, r)
+	}
+	if r, err := datatype.IndexValueRange("Uint", 1<<40, nil); err != nil || r[0] != r[1] {
+		t.Fatalf("want an empty range, got %q (%v)", r, err)
+	}
+}
+
+func TestIndexFieldParse(t *testing.T) {
+	type ParseType struct {
+		Int    int64     `kodb:"index"`
+		Uint   uint16    `kodb:"index"`
+		String string    `kodb:"index,zero"`
+		Float  float32   `kodb:"index"`
+		Bool   bool      `kodb:"index"`
+		Time   time.Time `kodb:"index"`
+		Bytes  []byte    `kodb:"index"`
+		Hash   [4]byte   `kodb:"index"`
+		Color  textColor `kodb:"index"`
+		Text   textUUID  `kodb:"index"`
+	}
+	datatype, err := NewDataType("ParseType", ParseType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip := func(field string, v interface{}) (interface{}, bool) {
+		ifield, err := datatype.getIndexField(field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := ifield.FormatInterface(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return datatype.ParseIndexValue(field, s)
+	}
+
+	type testCase struct {
+		field string
+		value interface{}
+	}
+	testCases := []testCase{
+		{"Int", int64(-42)},
+		{"Int", int64(math.MaxInt64)},
+		{"Uint", uint16(7)},
+		{"String", "hello"},
+		{"String", ""},
+		{"Float", float32(-1.5)},
+		{"Float", float32(0)},
+		{"Bool", true},
+		{"Time", time.Unix(1600000000, 123).UTC()},
+		{"Bytes", []byte{1, 2, 0xff}},
+		{"Hash", [4]byte{1, 2, 0, 0xff}},
+		{"Color", textColor(1)},
+	}
+	for i, tc := range testCases {
+		v, ok := roundTrip(tc.field, tc.value)
+		if !ok {
+			t.Errorf("%d: value %v of %s field must be parsed", i, tc.value, tc.field)
+			continue
+		}
+		if !reflect.DeepEqual(v, tc.value) {
+			t.Errorf("%d: want %#v, got %#v", i, tc.value, v)
+		}
+	}
+
+	if _, ok := roundTrip("Text", textUUID{1}); ok {
+		t.Errorf("values in the text form must not be parsed")
+	}
+	if _, ok := datatype.ParseIndexValue("Int", "xyz"); ok {
+		t.Errorf("invalid index values must not be parsed")
+	}
+	if _, ok := datatype.ParseIndexValue("Missing", "x\x00"); ok {
+		t.Errorf("values of unknown fields must not be parsed")
+	}
+}
+
+type textColor int
+
+func (c textColor) String() string { return [...]string{"red", "green"}[c] }
+
+type textUUID [4]byte
+
+func (u textUUID) String() string { return fmt.Sprintf("%x", u[:]) }
+
+type textVersion struct{ Major, Minor int }
+
+func (v *textVersion) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("v%d.%d", v.Major, v.Minor)), nil
+}
+
+func (v textVersion) String() string { return "unused" }
+
+type textOverride struct{ Name string }
+
+func (v textOverride) String() string { return "unused" }
+
+func TestIndexFieldTextFormat(t *testing.T) {
+	type TextType struct {
+		Color    textColor    `kodb:"index"`
+		ID       textUUID     `kodb:"index"`
+		Version  textVersion  `kodb:"index"`
+		Override textOverride `kodb:"index"`
+	}
+	stringer := func(v reflect.Value) (string, error) {
+		return "override-" + v.Interface().(textOverride).Name, nil
+	}
+	if err := RegisterIndexFieldType(reflect.TypeOf(textOverride{}), stringer); err != nil {
+		if !errors.Is(err, os.ErrExist) {
+			t.Fatal(err)
+		}
+	}
+	datatype, err := NewDataType("TextType", TextType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ovalue := reflect.ValueOf(TextType{
+		Color:    1,
+		ID:       textUUID{0xde, 0xad, 0xbe, 0xef},
+		Version:  textVersion{1, 2},
+		Override: textOverride{"x"},
+	})
+	testcases := []struct {
+		field, want string
+	}{
+		// Native kinds are preferred over the fmt.Stringer interface.
+		{"Color", fmt.Sprintf("%016x", uint64(1)^(1<<63))},
+		{"ID", "deadbeef"},
+		// TextMarshaler interface is preferred over the fmt.Stringer interface.
+		{"Version", "v1.2"},
+		// User-defined conversions are preferred over the interfaces.
+		{"Override", "override-x"},
+	}
+	for i, tc := range testcases {
+		ifield, err := datatype.getIndexField(tc.field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ifield.multi {
+			t.Fatalf("testcase %d: field %s must not be multi-valued", i, tc.field)
+		}
+		got, err := ifield.ToString(ovalue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("testcase %d: want %q got %q", i, tc.want, got)
+		}
+	}
+}
@@ -12,6 +12,8 @@ import (
 const (
 	ObjectKeyspace = "ob"
 	IndexKeyspace  = "ix"
+	UniqueKeyspace = "ux"
+	TextKeyspace   = "ft"
 )
 
 // ObjectKey holds the user specified key with the ObjectKeyspace prefix. For
@@ -31,17 +33,22 @@ type IndexKey string
 
 func NewObjectKey(key string) (ObjectKey, error) {
 	if !path.IsAbs(key) {
-		return "", fmt.Errorf("key must be an absolute path: %w", os.ErrInvalid)
+		return "", fmt.Errorf("key %q must be an absolute path: %w", key, ErrInvalidKey)
 	}
 	if s := path.Clean(key); s != key {
-		return "", fmt.Errorf("key must be a clean path: %w", os.ErrInvalid)
+		return "", fmt.Errorf("key %q must be a clean path: %w", key, ErrInvalidKey)
 	}
 	return ObjectKey(path.Join("/", ObjectKeyspace, key)), nil
 }
 
+// KeyspaceRange returns the [begin, end) range of all keys in a keyspace.
+func KeyspaceRange(keyspace string) [2]string {
+	return [2]string{"/" + keyspace + "/", "/" + keyspace + string([]byte{'/' + 1})}
+}
+
 func ParseObjectKey(s string) (ObjectKey, error) {
 	if !strings.HasPrefix(s, "/"+ObjectKeyspace) {
-		return "", fmt.Errorf("not an object key: %w", os.ErrInvalid)
+		return "", fmt.Errorf("%q is not an object key: %w", s, ErrInvalidKey)
 	}
 	return ObjectKey(s), nil
 }
@@ -61,10 +68,69 @@ func NewIndexKey(okey ObjectKey, typeName, fieldName, fieldValue string) (IndexK
 	if len(typeName) == 0 || len(fieldName) == 0 || len(fieldValue) == 0 {
 		return "", fmt.Errorf("type name/field name/field value can't be empty: %w", os.ErrInvalid)
 	}
-	s := path.Join("/", IndexKeyspace, url.PathEscape(typeName), url.PathEscape(fieldName), url.PathEscape(fieldValue), string(okey))
+	s := path.Join("/", IndexKeyspace, url.PathEscape(typeName), url.PathEscape(fieldName), escapeFieldValue(fieldValue), string(okey))
 	return IndexKey(s), nil
 }
 
+// NewTextKey returns the full-text index key for a token of a text field. Text
+// keys use the IndexKey format, but with the TextKeyspace prefix. For example,
+// token "hello" in the Body field of a Post type object would be represented
+// as below:
+//
+//     /ft/Post/Body/hello/ob/a/b/c
+//
+func NewTextKey(okey ObjectKey, typeName, fieldName, token string) (IndexKey, error) {
+	if len(okey) == 0 {
+		return "", fmt.Errorf("object key cannot be empty: %w", os.ErrInvalid)
+	}
+	if len(typeName) == 0 || len(fieldName) == 0 || len(token) == 0 {
+		return "", fmt.Errorf("type name/field name/token can't be empty: %w", os.ErrInvalid)
+	}
+	s := path.Join("/", TextKeyspace, url.PathEscape(typeName), url.PathEscape(fieldName), escapeFieldValue(token), string(okey))
+	return IndexKey(s), nil
+}
+
+// NewTextTokenRange returns the [begin, end) range of full-text index keys for
+// a token of a text field.
+func NewTextTokenRange(typeName, fieldName, token string) ([2]string, error) {
+	if len(typeName) == 0 || len(fieldName) == 0 || len(token) == 0 {
+		return [2]string{}, fmt.Errorf("type name/field name/token can't be empty: %w", os.ErrInvalid)
+	}
+	s := path.Join("/", TextKeyspace, url.PathEscape(typeName), url.PathEscape(fieldName), escapeFieldValue(token))
+	return [2]string{s + "/", s + string([]byte{'/' + 1})}, nil
+}
+
+// IndexFieldPrefix returns the common prefix for all index keys of a data
+// type's field. For example, index keys of the User type's Phone field share
+// the following prefix:
+//
+//     /ix/User/Phone/
+//
+func IndexFieldPrefix(typeName, fieldName string) (string, error) {
+	if len(typeName) == 0 || len(fieldName) == 0 {
+		return "", fmt.Errorf("type name/field name can't be empty: %w", os.ErrInvalid)
+	}
+	s := path.Join("/", IndexKeyspace, url.PathEscape(typeName), url.PathEscape(fieldName))
+	return s + "/", nil
+}
+
+// NewIndexValueRange returns the [begin, end) range of index keys with field
+// values in the closed interval [lo, hi]. Field values must be encoded in an
+// order-preserving form. An empty lo or hi value leaves the range unbounded on
+// that side.
+func NewIndexValueRange(typeName, fieldName, lo, hi string) ([2]string, error) {
+	prefix, err := IndexFieldPrefix(typeName, fieldName)
+	if err != nil {
+		return [2]string{}, err
+	}
+	begin := prefix + escapeFieldValue(lo)
+	end := prefix[:len(prefix)-1] + string([]byte{'/' + 1})
+	if len(hi) > 0 {
+		end = prefix + escapeFieldValue(hi) + string([]byte{'/' + 1})
+	}
+	return [2]string{begin, end}, nil
+}
+
 func ParseIndexKey(s string) (IndexKey, error) {
 	keyspacePos := indexRuneN(s, '/', 1)
 	typeNamePos := indexRuneN(s, '/', 2)
@@ -72,7 +138,7 @@ func ParseIndexKey(s string) (IndexKey, error) {
 	fieldValuePos := indexRuneN(s, '/', 4)
 	objectKeyPos := indexRuneN(s, '/', 5)
 	if keyspacePos != 0 || typeNamePos == -1 || fieldNamePos == -1 || fieldValuePos == -1 || objectKeyPos == -1 {
-		return "", fmt.Errorf("index key format is invalid: %w", os.ErrInvalid)
+		return "", fmt.Errorf("index key %q format is invalid: %w", s, ErrInvalidKey)
 	}
 	keyspace := s[keyspacePos+1 : typeNamePos]
 	typeName := s[typeNamePos+1 : fieldNamePos]
@@ -80,16 +146,12 @@ func ParseIndexKey(s string) (IndexKey, error) {
 	fieldValue := s[fieldValuePos+1 : objectKeyPos]
 	objectKey := s[objectKeyPos:]
 	if len(keyspace) == 0 || len(typeName) == 0 || len(fieldName) == 0 || len(fieldValue) == 0 || len(objectKey) == 0 {
-		return "", fmt.Errorf("index key format is illegal: %w", os.ErrInvalid)
+		return "", fmt.Errorf("index key %q format is illegal: %w", s, ErrInvalidKey)
 	}
-	if _, err := url.PathUnescape(typeName); err != nil {
-		return "", err
-	}
-	if _, err := url.PathUnescape(fieldName); err != nil {
-		return "", err
-	}
-	if _, err := url.PathUnescape(fieldValue); err != nil {
-		return "", err
+	for _, part := range []string{typeName, fieldName, fieldValue} {
+		if _, err := url.PathUnescape(part); err != nil {
+			return "", fmt.Errorf("index key %q is not escaped properly (%v): %w", s, err, ErrInvalidKey)
+		}
 	}
 	if _, err := ParseObjectKey(objectKey); err != nil {
 		return "", err
@@ -101,6 +163,11 @@ func (ik IndexKey) String() string {
 	return string(ik)
 }
 
+// IsTextKey returns true if the index key belongs to the full-text index.
+func (ik IndexKey) IsTextKey() bool {
+	return strings.HasPrefix(string(ik), "/"+TextKeyspace+"/")
+}
+
 func (ik IndexKey) GetTypeName() (string, error) {
 	s := string(ik)
 	p := indexRuneN(s, '/', 2)
@@ -108,7 +175,7 @@ func (ik IndexKey) GetTypeName() (string, error) {
 	if p != -1 && q != -1 && p+1 < q {
 		return url.PathUnescape(s[p+1 : q])
 	}
-	return "", fmt.Errorf("index key has no type name: %w", os.ErrInvalid)
+	return "", fmt.Errorf("index key %q has no type name: %w", s, ErrInvalidKey)
 }
 
 func (ik IndexKey) GetFieldName() (string, error) {
@@ -118,7 +185,7 @@ func (ik IndexKey) GetFieldName() (string, error) {
 	if p != -1 && q != -1 && p+1 < q {
 		return url.PathUnescape(s[p+1 : q])
 	}
-	return "", fmt.Errorf("index key has no field name: %w", os.ErrInvalid)
+	return "", fmt.Errorf("index key %q has no field name: %w", s, ErrInvalidKey)
 }
 
 func (ik IndexKey) GetFieldValue() (string, error) {
@@ -128,7 +195,7 @@ func (ik IndexKey) GetFieldValue() (string, error) {
 	if p != -1 && q != -1 && p+1 < q {
 		return url.PathUnescape(s[p+1 : q])
 	}
-	return "", fmt.Errorf("index key has no field value: %w", os.ErrInvalid)
+	return "", fmt.Errorf("index key %q has no field value: %w", s, ErrInvalidKey)
 }
 
 func (ik IndexKey) GetObjectKey() (ObjectKey, error) {
@@ -137,7 +204,7 @@ func (ik IndexKey) GetObjectKey() (ObjectKey, error) {
 	if p != -1 && p+1 < len(ik) {
 		return ObjectKey(s[p:]), nil
 	}
-	return "", fmt.Errorf("index key has no object key part: %w", os.ErrInvalid)
+	return "", fmt.Errorf("index key %q has no object key part: %w", s, ErrInvalidKey)
 }
 
 func (ik IndexKey) WithObjectKey(okey ObjectKey) (IndexKey, error) {
@@ -146,20 +213,86 @@ func (ik IndexKey) WithObjectKey(okey ObjectKey) (IndexKey, error) {
 	if p != -1 {
 		return IndexKey(s[:p] + string(okey)), nil
 	}
-	return "", fmt.Errorf("index key has no object key part: %w", os.ErrInvalid)
+	return "", fmt.Errorf("index key %q has no object key part: %w", s, ErrInvalidKey)
+}
+
+// UniqueKey returns the key that identifies an index field value irrespective
+// of the object key, with UniqueKeyspace prefix. For example, unique key for
+// the index key /ix/User/Name/alice/ob/a/b/c would be:
+//
+//     /ux/User/Name/alice
+//
+func (ik IndexKey) UniqueKey() (string, error) {
+	s := string(ik)
+	p := indexRuneN(s, '/', 2)
+	q := indexRuneN(s, '/', 5)
+	if p == -1 || q == -1 {
+		return "", fmt.Errorf("index key %q is invalid: %w", s, ErrInvalidKey)
+	}
+	return "/" + UniqueKeyspace + s[p:q], nil
 }
 
 func (ik IndexKey) IndexKeyRange() ([2]string, error) {
 	s := string(ik)
 	p := indexRuneN(s, '/', 5)
 	if p == -1 {
-		return [2]string{}, fmt.Errorf("invalid index key: %w", os.ErrInvalid)
+		return [2]string{}, fmt.Errorf("index key %q is invalid: %w", s, ErrInvalidKey)
 	}
 	begin := s[:p+1]
 	end := s[:p] + string([]byte{'/' + 1})
 	return [2]string{begin, end}, nil
 }
 
+// ExactRangeKey returns the index key of an object in the range of index
+// keys for a single field value or a full-text token.
+func ExactRangeKey(r [2]string, okey ObjectKey) IndexKey {
+	return IndexKey(strings.TrimSuffix(r[0], "/") + string(okey))
+}
+
+// NewIndexPrefixRange returns the [begin, end) range of index keys with field
+// values that begin with the prefix. Field values must be encoded in a
+// prefix-preserving form.
+func NewIndexPrefixRange(typeName, fieldName, prefix string) ([2]string, error) {
+	p, err := IndexFieldPrefix(typeName, fieldName)
+	if err != nil {
+		return [2]string{}, err
+	}
+	begin := p + escapeFieldValue(prefix)
+	return [2]string{begin, prefixEnd(begin)}, nil
+}
+
+// prefixEnd returns the smallest string that is larger than all strings with
+// the prefix. Returns empty string if there is no such string.
+func prefixEnd(prefix string) string {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if c := prefix[i]; c != 0xff {
+			return prefix[:i] + string([]byte{c + 1})
+		}
+	}
+	return ""
+}
+
+// escapeFieldValue escapes the field value component of index keys. Unlike
+// url.PathEscape, it preserves the lexical order of the field values: all
+// bytes less than '0', which includes the '/' separator and the '%' escape
+// character, are percent-encoded and all other bytes are kept as is. Escaped
+// values can be decoded with the url.PathUnescape function.
+func escapeFieldValue(s string) string {
+	const hex = "0123456789ABCDEF"
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < '0' {
+			sb.WriteByte('%')
+			sb.WriteByte(hex[c>>4])
+			sb.WriteByte(hex[c&15])
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
 func SortIndexKeys(iks []IndexKey) {
 	sort.Slice(iks, func(i, j int) bool { return iks[i] < iks[j] })
 }
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"fmt"
+	"net/url"
 	"testing"
 )
 
@@ -112,3 +113,67 @@ func TestIndexKeyEscapes(t *testing.T) {
 		t.Fatalf("want %s got %s", okey, v)
 	}
 }
+
+func TestEscapeFieldValueOrder(t *testing.T) {
+	values := []string{"", "\x01", " ", "-", "/", "0", "9", "A", "a", "a b", "a/b", "a0", "ab", "b", "\xff"}
+	for i := 1; i < len(values); i++ {
+		a, b := escapeFieldValue(values[i-1]), escapeFieldValue(values[i])
+		if a >= b {
+			t.Fatalf("escaped value %q must be less than %q", a, b)
+		}
+	}
+	for _, v := range values {
+		s, err := url.PathUnescape(escapeFieldValue(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != v {
+			t.Fatalf("want %q got %q", v, s)
+		}
+	}
+}
+
+func TestUniqueKey(t *testing.T) {
+	okey, err := NewObjectKey("/a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ikey, err := NewIndexKey(okey, "User", "Name", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ukey, err := ikey.UniqueKey(); err != nil {
+		t.Fatal(err)
+	} else if ukey != "/ux/User/Name/alice" {
+		t.Fatalf("unique key %q is in unexpected format", ukey)
+	}
+}
+
+func TestExactRangeKey(t *testing.T) {
+	okey, err := NewObjectKey("/a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ikey, err := NewIndexKey(okey, "User", "Name", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewIndexValueRange("User", "Name", "alice", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k := ExactRangeKey(r, okey); k != ikey {
+		t.Fatalf("want %q got %q", ikey, k)
+	}
+
+	tkey, err := NewTextKey(okey, "Post", "Body", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err = NewTextTokenRange("Post", "Body", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if k := ExactRangeKey(r, okey); k != tkey {
+		t.Fatalf("want %q got %q", tkey, k)
+	}
+}
@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// QueryField holds the metadata to match a field in the query predicates.
+// Indexed fields (and computed indexes) are matched through the index keys of
+// the objects. Other fields are matched through the index keys they would
+// have if they were indexed, including for their zero values, which are
+// computed from the objects when necessary.
+type QueryField struct {
+	dtype  *DataType
+	ifield *IndexField
+
+	// indexed when true, indicates that the field has index keys in the
+	// database.
+	indexed bool
+}
+
+// QueryField returns the query metadata for an index name or the dotted path
+// of a struct field.
+func (t *DataType) QueryField(name string) (*QueryField, error) {
+	for _, ifield := range t.indexFields {
+		if ifield.name == name {
+			return &QueryField{dtype: t, ifield: ifield, indexed: true}, nil
+		}
+	}
+	sfield, ok := t.structField(name)
+	if !ok {
+		if t.isComputedIndex(name) {
+			ifield, err := t.getIndexField(name)
+			if err != nil {
+				return nil, err
+			}
+			return &QueryField{dtype: t, ifield: ifield, indexed: true}, nil
+		}
+		return nil, fmt.Errorf("field %s is not a field of %s type: %w", name, t.name, os.ErrInvalid)
+	}
+	// Fields are matched as if they were indexed with the zero option, so that
+	// zero values can also be matched.
+	tag := `kodb:"index,zero"`
+	if isMultiValued(sfield.Type) {
+		tag = `kodb:"index"`
+	}
+	sfield.Tag = reflect.StructTag(tag)
+	ifields, err := NewIndexFields(sfield)
+	if err != nil {
+		return nil, fmt.Errorf("field %s cannot be queried: %w", name, err)
+	}
+	return &QueryField{dtype: t, ifield: ifields[0]}, nil
+}
+
+// structField returns the struct field for a dotted path of field names, with
+// the field's Index updated to it's position from the object.
+func (t *DataType) structField(name string) (reflect.StructField, bool) {
+	var sfield reflect.StructField
+	var position []int
+	stype := t.gotype
+	for i, part := range strings.Split(name, ".") {
+		if i > 0 {
+			if isStructPtr(stype) {
+				stype = stype.Elem()
+			}
+			if !isStruct(stype) {
+				return reflect.StructField{}, false
+			}
+		}
+		f, ok := stype.FieldByName(part)
+		if !ok || len(f.PkgPath) > 0 {
+			return reflect.StructField{}, false
+		}
+		sfield, stype = f, f.Type
+		position = append(position, f.Index...)
+	}
+	sfield.Name = name
+	sfield.Index = position
+	return sfield, true
+}
+
+// Name returns the index name of the field.
+func (q *QueryField) Name() string {
+	return q.ifield.name
+}
+
+// Indexed returns true if the field has index keys in the database.
+func (q *QueryField) Indexed() bool {
+	return q.indexed
+}
+
+// ValueRange returns the index keyspace range for the field values between lo
+// and hi. A nil lo or hi value leaves the range unbounded on that side and
+// the open flags exclude the boundary values from the range.
+func (q *QueryField) ValueRange(lo, hi interface{}, loOpen, hiOpen bool) ([2]string, error) {
+	return q.ifield.valueRange(q.dtype.name, lo, hi, loOpen, hiOpen)
+}
+
+// ZeroField returns a query field that matches the field values computed from
+// the objects, including the zero values, like the fields without an index.
+// Returns nil if the field is not indexed or if it's zero values are also
+// indexed.
+func (q *QueryField) ZeroField() *QueryField {
+	f := q.ifield
+	if !q.indexed || f.zero || f.multi || f.text || q.dtype.isComputedIndex(f.name) {
+		return nil
+	}
+	zf := *f
+	zf.zero = true
+	return &QueryField{dtype: q.dtype, ifield: &zf}
+}
+
+// ZeroValue returns the zero value of the field.
+func (q *QueryField) ZeroValue() interface{} {
+	return reflect.Zero(q.ifield.vtype).Interface()
+}
+
+// ZeroKey returns the index value of the field's zero value, in the same form
+// as the SortKey method. Returns empty string if the zero value is not
+// indexed.
+func (q *QueryField) ZeroKey() (string, error) {
+	ik, err := q.zeroIndexKey()
+	if err != nil || len(ik) == 0 {
+		return "", err
+	}
+	return ik.GetFieldValue()
+}
+
+// HasZero returns true if the index key of the field's zero value is in any
+// of the index keyspace ranges.
+func (q *QueryField) HasZero(ranges [][2]string) (bool, error) {
+	ik, err := q.zeroIndexKey()
+	if err != nil || len(ik) == 0 {
+		return false, err
+	}
+	for _, r := range ranges {
+		if k := ik.String(); k >= r[0] && k < r[1] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// zeroIndexKey returns an index key with the field's zero value. Returns
+// empty index key if the zero value is not indexed.
+func (q *QueryField) zeroIndexKey() (IndexKey, error) {
+	fstring, err := q.ifield.formatZero(reflect.Zero(q.ifield.vtype))
+	if err != nil || len(fstring) == 0 {
+		return "", err
+	}
+	okey, err := NewObjectKey("/x")
+	if err != nil {
+		return "", err
+	}
+	return NewIndexKey(okey, q.dtype.name, q.ifield.name, fstring)
+}
+
+// PrefixRange returns the index keyspace range for the string field values
+// beginning with the prefix.
+func (q *QueryField) PrefixRange(prefix string) ([2]string, error) {
+	p, err := q.ifield.FormatPrefix(prefix)
+	if err != nil {
+		return [2]string{}, err
+	}
+	return NewIndexPrefixRange(q.dtype.name, q.ifield.name, p)
+}
+
+// IndexKeys returns the index keys of an object for the field. Index keys of
+// the indexed fields are taken from the stored value and others are computed
+// from the object, which must be of the field's data type.
+func (q *QueryField) IndexKeys(v *Value, ob interface{}) ([]IndexKey, error) {
+	if q.indexed {
+		return v.IndexKeys, nil
+	}
+	ovalue, ok := q.dtype.goodValue(ob)
+	if !ok {
+		return nil, fmt.Errorf("input object of type %T is not a struct or pointer to struct of %s type: %w", ob, q.dtype.name, ErrTypeMismatch)
+	}
+	fstrings, err := q.ifield.ToStrings(ovalue)
+	if err != nil {
+		return nil, err
+	}
+	iks := make([]IndexKey, 0, len(fstrings))
+	for _, fstring := range fstrings {
+		ik, err := NewIndexKey(v.ObjectKey, q.dtype.name, q.ifield.name, fstring)
+		if err != nil {
+			return nil, err
+		}
+		iks = append(iks, ik)
+	}
+	return iks, nil
+}
+
+// SortKey returns the smallest value of the field in an object, in it's index
+// key form, so that objects can be ordered by their field values. Returns
+// empty string if the object has no value for the field.
+func (q *QueryField) SortKey(ob interface{}) (string, error) {
+	if q.indexed {
+		ikMap, err := q.dtype.IndexKeyMap(ob)
+		if err != nil {
+			return "", err
+		}
+		// Index keys of a field are in the ascending order of their values.
+		if iks := ikMap[q.ifield.name]; len(iks) > 0 {
+			return iks[0].GetFieldValue()
+		}
+		return "", nil
+	}
+	ovalue, ok := q.dtype.goodValue(ob)
+	if !ok {
+		return "", fmt.Errorf("input object of type %T is not a struct or pointer to struct of %s type: %w", ob, q.dtype.name, ErrTypeMismatch)
+	}
+	fstrings, err := q.ifield.ToStrings(ovalue)
+	if err != nil || len(fstrings) == 0 {
+		return "", err
+	}
+	return fstrings[0], nil
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestQueryField(t *testing.T) {
+	type Address struct {
+		City string
+	}
+	type User struct {
+		Age     int `kodb:"index"`
+		Name    string
+		Address *Address
+		Friends []string
+		Home    Address
+	}
+	dt, err := NewDataType("User", User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f, err := dt.QueryField("Age"); err != nil || !f.Indexed() {
+		t.Fatalf("want indexed field got %v (%v)", f, err)
+	}
+	for _, name := range []string{"Name", "Address.City", "Friends"} {
+		if f, err := dt.QueryField(name); err != nil || f.Indexed() || f.Name() != name {
+			t.Fatalf("want non-indexed field %s got %v (%v)", name, f, err)
+		}
+	}
+	for _, name := range []string{"Missing", "Home", "Address.Missing", "Age.X"} {
+		if _, err := dt.QueryField(name); !errors.Is(err, os.ErrInvalid) {
+			t.Fatalf("want os.ErrInvalid for field %s got %v", name, err)
+		}
+	}
+
+	f, err := dt.QueryField("Address.City")
+	if err != nil {
+		t.Fatal(err)
+	}
+	okey, err := NewObjectKey("/u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := User{Address: &Address{City: "x"}}
+	iks, err := f.IndexKeys(&Value{ObjectKey: okey}, &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eq, err := f.ValueRange("x", "x", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gt, err := f.ValueRange("x", nil, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(iks) != 1 || iks[0].String() < eq[0] || iks[0].String() >= eq[1] {
+		t.Fatalf("index key %v must be in the range %v", iks, eq)
+	}
+	if iks[0].String() >= gt[0] && iks[0].String() < gt[1] {
+		t.Fatalf("index key %v must not be in the range %v", iks, gt)
+	}
+	if iks, err := f.IndexKeys(&Value{ObjectKey: okey}, &User{}); err != nil || len(iks) != 0 {
+		t.Fatalf("nil nested struct pointers must have no index keys: %v (%v)", iks, err)
+	}
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// stopWords holds common english words that are not useful for full-text
+// search.
+var stopWords = map[string]struct{}{}
+
+func init() {
+	words := []string{
+		"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in",
+		"into", "is", "it", "no", "not", "of", "on", "or", "such", "that", "the",
+		"their", "then", "there", "these", "they", "this", "to", "was", "will",
+		"with",
+	}
+	for _, w := range words {
+		stopWords[w] = struct{}{}
+	}
+}
+
+// Tokenize splits the text into lowercase words on the word boundaries. Words
+// are sequences of unicode letters and digits. Stop words are dropped when
+// stop is true.
+func Tokenize(text string, stop bool) []string {
+	isSeparator := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+	var tokens []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), isSeparator) {
+		if _, ok := stopWords[w]; ok && stop {
+			continue
+		}
+		tokens = append(tokens, w)
+	}
+	return tokens
+}
+
+// uniqueStrings returns the input strings in sorted order without duplicates.
+func uniqueStrings(vs []string) []string {
+	if len(vs) == 0 {
+		return nil
+	}
+	sort.Strings(vs)
+	n := 1
+	for i := 1; i < len(vs); i++ {
+		if vs[i] != vs[n-1] {
+			vs[n] = vs[i]
+			n++
+		}
+	}
+	return vs[:n]
+}
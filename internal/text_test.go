@@ -0,0 +1,26 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	testcases := []struct {
+		text string
+		stop bool
+		want string
+	}{
+		{"", false, ""},
+		{"Hello, World!", false, "hello,world"},
+		{"The quick brown-fox", false, "the,quick,brown,fox"},
+		{"The quick brown-fox", true, "quick,brown,fox"},
+		{"  Año 2021: Ünïcode\twords ", false, "año,2021,ünïcode,words"},
+		{"a/b%c_d", false, "a,b,c,d"},
+	}
+	for i, tc := range testcases {
+		if got := strings.Join(Tokenize(tc.text, tc.stop), ","); got != tc.want {
+			t.Fatalf("testcase %d: want %q got %q", i, tc.want, got)
+		}
+	}
+}
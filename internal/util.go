@@ -2,6 +2,7 @@ package internal
 
 import (
 	"encoding/gob"
+	"math"
 	"reflect"
 	"strings"
 )
@@ -39,6 +40,84 @@ func isStructPtr(t reflect.Type) bool {
 	return false
 }
 
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// isConvertible returns true if values of type src can be converted to values
+// of type dst without changing their meaning. Unlike the
+// reflect.Type.ConvertibleTo, integers are not convertible to strings and
+// floating-point numbers are not convertible to integers.
+func isConvertible(src, dst reflect.Type) bool {
+	if !src.ConvertibleTo(dst) {
+		return false
+	}
+	if isNumber(src.Kind()) && isNumber(dst.Kind()) {
+		return !isFloat(src.Kind()) || isFloat(dst.Kind())
+	}
+	return src.Kind() == dst.Kind()
+}
+
+// checkRange compares a number with the range of values of a numeric type.
+// Returns -1 if the number is smaller than all values of the type, 1 if it is
+// larger than all values of the type and 0 otherwise, including when either
+// of them is not a number.
+func checkRange(v reflect.Value, dst reflect.Type) int {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, bits := v.Int(), dst.Bits()
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if x < -1<<(bits-1) {
+				return -1
+			}
+			if x > 1<<(bits-1)-1 {
+				return 1
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if x < 0 {
+				return -1
+			}
+			if bits < 64 && uint64(x) > 1<<bits-1 {
+				return 1
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, bits := v.Uint(), dst.Bits()
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if x > 1<<(bits-1)-1 {
+				return 1
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if bits < 64 && x > 1<<bits-1 {
+				return 1
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		if dst.Kind() == reflect.Float32 {
+			if x := v.Float(); x > math.MaxFloat32 && !math.IsInf(x, 1) {
+				return 1
+			} else if x < -math.MaxFloat32 && !math.IsInf(x, -1) {
+				return -1
+			}
+		}
+	}
+	return 0
+}
+
 func getStructType(ob interface{}) (reflect.Type, bool) {
 	otype := reflect.TypeOf(ob)
 	if isStruct(otype) {
@@ -19,6 +19,10 @@ type Value struct {
 	// References keeps track of all index keys that can refer to this object.
 	// any value. Index keys are in stored lexical, ascending order.
 	IndexKeys []IndexKey
+
+	// Projection holds the serialized projected fields of the object, which is
+	// stored as the value for all index keys.
+	Projection string
 }
 
 func NewValue(okey ObjectKey, object interface{}, datatype *DataType) (*Value, error) {
@@ -30,20 +34,39 @@ func NewValue(okey ObjectKey, object interface{}, datatype *DataType) (*Value, e
 	if err != nil {
 		return nil, err
 	}
+	tks, err := datatype.TextKeys(object)
+	if err != nil {
+		return nil, err
+	}
 	var iks []IndexKey
-	for _, ik := range ikMap {
-		x, err := ik.WithObjectKey(okey)
+	for _, keys := range ikMap {
+		for _, ik := range keys {
+			x, err := ik.WithObjectKey(okey)
+			if err != nil {
+				return nil, err
+			}
+			iks = append(iks, x)
+		}
+	}
+	// Full-text index keys are maintained along with the index keys.
+	for _, tk := range tks {
+		x, err := tk.WithObjectKey(okey)
 		if err != nil {
 			return nil, err
 		}
 		iks = append(iks, x)
 	}
 	SortIndexKeys(iks)
+	p, err := datatype.Projection(object)
+	if err != nil {
+		return nil, err
+	}
 	v := &Value{
-		Data:      s,
-		Type:      datatype.name,
-		ObjectKey: okey,
-		IndexKeys: iks,
+		Data:       s,
+		Type:       datatype.name,
+		ObjectKey:  okey,
+		IndexKeys:  iks,
+		Projection: p,
 	}
 	return v, nil
 }
@@ -68,13 +91,15 @@ func ParseValue(s string) (*Value, error) {
 	return v, nil
 }
 
+// Encode returns the value in it's serialized form.
+//
 // TODO: Allow for json encoding for easier inspection.
-func (v *Value) String() string {
+func (v *Value) Encode() (string, error) {
 	var sb strings.Builder
 	if err := gob.NewEncoder(&sb).Encode(v); err != nil {
-		panic("unexpected gob encode failure")
+		return "", fmt.Errorf("could not encode value for %s: %w", v.ObjectKey, err)
 	}
-	return sb.String()
+	return sb.String(), nil
 }
 
 func (v *Value) HasAllIndexKeys(iks []IndexKey) bool {
@@ -0,0 +1,595 @@
+package kodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bvkgo/kodb/internal"
+)
+
+// Statement holds a parsed textual query. Statements are written as a
+// registered type name, optionally followed by the where, order by and limit
+// clauses. For example:
+//
+//	User where Age >= 18 and Status in ("active", "trial") order by Age limit 20
+//
+// Where clause supports the =, !=, <, <=, >, >= comparisons, the in,
+// between-and and like (with a trailing % wildcard) predicates and the and,
+// or, not operators with parenthesis. Values are double (or single) quoted
+// strings, numbers, the true and false literals and the time literals, which
+// are quoted RFC 3339 timestamps after the time keyword, like time
+// "2021-01-02T15:04:05Z". Keywords are case insensitive.
+type Statement struct {
+	// TypeName holds the registered type name of the objects.
+	TypeName string
+
+	// Query holds the where clause of the statement, which is nil when the
+	// statement has no where clause.
+	Query *Query
+
+	// OrderBy holds the field name to order the objects, which is empty when
+	// the statement has no order by clause. Desc is true when the objects are
+	// ordered in the descending order.
+	OrderBy string
+	Desc    bool
+
+	// Limit holds the maximum number of objects, which is zero when the
+	// statement has no limit clause.
+	Limit int
+}
+
+// Row holds an object returned from a textual query.
+type Row struct {
+	// Key holds the user key of the object.
+	Key string
+
+	// Object holds a pointer to the object, which is of the Go type registered
+	// with the type name.
+	Object interface{}
+}
+
+// ParseStatement parses a textual query.
+func ParseStatement(text string) (*Statement, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	return p.parseStatement()
+}
+
+// RunStatement parses and runs a textual query and returns the matching
+// objects. Type name of the query is resolved to the Go type through the
+// registered data types.
+//
+// Objects are returned in the same order as the FindByQuery api when the
+// statement has no order by clause. Statements ordered by an indexed field
+// scan the field's index in the requested order, so only the objects up to
+// the limit are loaded, but statements ordered by other fields load all
+// matching objects to sort them in memory. Zero values of the indexed fields
+// without the zero option are found with an object scan, when necessary, and
+// they are ordered at their position among the other values, same as for the
+// fields without an index. Objects without a value for the order by field (eg:
+// nil pointers) are returned last.
+func (t *Tx) RunStatement(ctx context.Context, text string) ([]*Row, error) {
+	stmt, err := ParseStatement(text)
+	if err != nil {
+		return nil, err
+	}
+	datatype, err := internal.GetDataTypeByName(stmt.TypeName)
+	if err != nil {
+		return nil, err
+	}
+	if len(stmt.OrderBy) == 0 {
+		var it Iter
+		it.SetLimit(stmt.Limit)
+		if err := t.FindByQuery(ctx, datatype.New(), stmt.Query, &it); err != nil {
+			return nil, err
+		}
+		return loadRows(ctx, datatype, &it)
+	}
+
+	order, err := datatype.QueryField(stmt.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+	if !order.Indexed() {
+		return t.sortStatement(ctx, datatype, order, stmt)
+	}
+
+	stream, err := t.newOrderedStream(ctx, datatype, stmt.Query, stmt.OrderBy, stmt.Desc)
+	if err != nil {
+		return nil, err
+	}
+	var it Iter
+	it.SetLimit(stmt.Limit)
+	it.reset(t, stream)
+	rows, err := loadRows(ctx, datatype, &it)
+	if err != nil {
+		return nil, err
+	}
+	full := stmt.Limit > 0 && len(rows) == stmt.Limit
+
+	// Zero values are not in the index without the zero option, so objects
+	// with the zero value are found with a separate query, unless all rows up
+	// to the limit are ordered before the zero value.
+	if zf := order.ZeroField(); zf != nil {
+		zkey, err := zf.ZeroKey()
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		for ; n < len(rows); n++ {
+			key, err := order.SortKey(rows[n].Object)
+			if err != nil {
+				return nil, err
+			}
+			if (key < zkey) == stmt.Desc {
+				break
+			}
+		}
+		if !full || n < len(rows) {
+			zq := Where(stmt.OrderBy).Eq(zf.ZeroValue())
+			if stmt.Query != nil {
+				zq = And(stmt.Query, zq)
+			}
+			zrows, err := t.sortStatement(ctx, datatype, nil, &Statement{Query: zq, Desc: stmt.Desc})
+			if err != nil {
+				return nil, err
+			}
+			merged := make([]*Row, 0, len(rows)+len(zrows))
+			merged = append(merged, rows[:n]...)
+			merged = append(merged, zrows...)
+			rows = append(merged, rows[n:]...)
+		}
+	}
+	if full {
+		return rows[:stmt.Limit], nil
+	}
+
+	// Objects without a value for the field are not in the index, so they are
+	// found with a separate query.
+	missing := Not(Where(stmt.OrderBy).Between(nil, nil))
+	if stmt.Query != nil {
+		missing = And(stmt.Query, missing)
+	}
+	rest, err := t.sortStatement(ctx, datatype, nil, &Statement{Query: missing, Desc: stmt.Desc})
+	if err != nil {
+		return nil, err
+	}
+	rows = append(rows, rest...)
+	if stmt.Limit > 0 && len(rows) > stmt.Limit {
+		rows = rows[:stmt.Limit]
+	}
+	return rows, nil
+}
+
+// sortStatement loads all objects matching the statement and sorts them by
+// the order field, which are ordered by their keys when the order field is
+// nil. Objects without a value for the order field are ordered last.
+func (t *Tx) sortStatement(ctx context.Context, datatype *internal.DataType, order *internal.QueryField, stmt *Statement) ([]*Row, error) {
+	var it Iter
+	if err := t.FindByQuery(ctx, datatype.New(), stmt.Query, &it); err != nil {
+		return nil, err
+	}
+	rows, err := loadRows(ctx, datatype, &it)
+	if err != nil {
+		return nil, err
+	}
+	sortKeys := make([]string, len(rows))
+	if order != nil {
+		for i, row := range rows {
+			if sortKeys[i], err = order.SortKey(row.Object); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Objects with same field value are ordered by their keys, so that the
+	// descending order is the reverse of the ascending order.
+	indexes := make([]int, len(rows))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		a, b := indexes[i], indexes[j]
+		if sortKeys[a] != sortKeys[b] {
+			if len(sortKeys[a]) == 0 || len(sortKeys[b]) == 0 {
+				return len(sortKeys[b]) == 0
+			}
+			return (sortKeys[a] < sortKeys[b]) != stmt.Desc
+		}
+		return (rows[a].Key < rows[b].Key) != stmt.Desc
+	})
+	sorted := make([]*Row, 0, len(rows))
+	for _, i := range indexes {
+		sorted = append(sorted, rows[i])
+	}
+	if stmt.Limit > 0 && len(sorted) > stmt.Limit {
+		sorted = sorted[:stmt.Limit]
+	}
+	return sorted, nil
+}
+
+// loadRows loads all remaining objects from the iterator.
+func loadRows(ctx context.Context, datatype *internal.DataType, it *Iter) ([]*Row, error) {
+	var rows []*Row
+	for {
+		row := &Row{Object: datatype.New()}
+		if err := it.LoadNext(ctx, &row.Key, row.Object); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return rows, nil
+			}
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenString
+	tokenNumber
+	tokenSymbol
+	tokenEOF
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// tokenize splits the query text into identifiers, quoted strings, numbers
+// and symbols.
+func tokenize(text string) ([]*token, error) {
+	var tokens []*token
+	for i := 0; i < len(text); {
+		// Identifiers can have Unicode letters, so the text is decoded into
+		// runes instead of bytes.
+		c, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case c == '"' || c == '\'':
+			j := i + 1
+			for ; j < len(text) && text[j] != byte(c); j++ {
+				if text[j] == '\\' && c == '"' {
+					j++
+				}
+			}
+			if j >= len(text) {
+				return nil, fmt.Errorf("unterminated string at position %d: %w", i, os.ErrInvalid)
+			}
+			s := text[i+1 : j]
+			if c == '"' {
+				x, err := strconv.Unquote(text[i : j+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid string at position %d: %w", i, os.ErrInvalid)
+				}
+				s = x
+			}
+			tokens = append(tokens, &token{kind: tokenString, text: s, pos: i})
+			i = j + 1
+		case isDigit(c) || (c == '-' && i+1 < len(text) && isDigit(rune(text[i+1]))):
+			j := i + 1
+			for ; j < len(text) && strings.ContainsRune("0123456789.eE+-", rune(text[j])); j++ {
+				// Signs are only valid after the exponent.
+				if (text[j] == '+' || text[j] == '-') && text[j-1] != 'e' && text[j-1] != 'E' {
+					break
+				}
+			}
+			tokens = append(tokens, &token{kind: tokenNumber, text: text[i:j], pos: i})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + size
+			for j < len(text) {
+				r, n := utf8.DecodeRuneInString(text[j:])
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '.' {
+					break
+				}
+				j += n
+			}
+			tokens = append(tokens, &token{kind: tokenIdent, text: text[i:j], pos: i})
+			i = j
+		default:
+			n := 1
+			if i+1 < len(text) {
+				switch text[i : i+2] {
+				case "<=", ">=", "!=", "==", "<>":
+					n = 2
+				}
+			}
+			if n == 1 && !strings.ContainsRune("=<>(),", c) {
+				return nil, fmt.Errorf("unexpected character %q at position %d: %w", c, i, os.ErrInvalid)
+			}
+			tokens = append(tokens, &token{kind: tokenSymbol, text: text[i : i+n], pos: i})
+			i += n
+		}
+	}
+	tokens = append(tokens, &token{kind: tokenEOF, pos: len(text)})
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []*token
+	next   int
+}
+
+func (p *parser) peek() *token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() *token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+// keyword returns true and advances the parser if the next token is the
+// keyword.
+func (p *parser) keyword(word string) bool {
+	if t := p.peek(); t.kind == tokenIdent && strings.EqualFold(t.text, word) {
+		p.next++
+		return true
+	}
+	return false
+}
+
+// symbol returns true and advances the parser if the next token is the
+// symbol.
+func (p *parser) symbol(sym string) bool {
+	if t := p.peek(); t.kind == tokenSymbol && t.text == sym {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	at := fmt.Sprintf("%q", t.text)
+	if t.kind == tokenEOF {
+		at = "end of query"
+	}
+	return fmt.Errorf("%s at position %d (%s): %w", fmt.Sprintf(format, args...), t.pos, at, os.ErrInvalid)
+}
+
+func (p *parser) parseStatement() (*Statement, error) {
+	stmt := new(Statement)
+	if t := p.peek(); t.kind != tokenIdent && t.kind != tokenString {
+		return nil, p.errorf("expected a type name")
+	}
+	stmt.TypeName = p.advance().text
+
+	if p.keyword("where") {
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Query = q
+	}
+	if p.keyword("order") {
+		if !p.keyword("by") {
+			return nil, p.errorf("expected by")
+		}
+		if p.peek().kind != tokenIdent {
+			return nil, p.errorf("expected a field name")
+		}
+		stmt.OrderBy = p.advance().text
+		if p.keyword("desc") {
+			stmt.Desc = true
+		} else {
+			p.keyword("asc")
+		}
+	}
+	if p.keyword("limit") {
+		t := p.peek()
+		n, err := strconv.Atoi(t.text)
+		if t.kind != tokenNumber || err != nil || n <= 0 {
+			return nil, p.errorf("expected a positive limit")
+		}
+		p.advance()
+		stmt.Limit = n
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.errorf("unexpected token")
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseOr() (*Query, error) {
+	q, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	qs := []*Query{q}
+	for p.keyword("or") {
+		x, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, x)
+	}
+	if len(qs) == 1 {
+		return q, nil
+	}
+	return Or(qs...), nil
+}
+
+func (p *parser) parseAnd() (*Query, error) {
+	q, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	qs := []*Query{q}
+	for p.keyword("and") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, x)
+	}
+	if len(qs) == 1 {
+		return q, nil
+	}
+	return And(qs...), nil
+}
+
+func (p *parser) parseNot() (*Query, error) {
+	if p.keyword("not") {
+		q, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return Not(q), nil
+	}
+	if p.symbol("(") {
+		q, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.symbol(")") {
+			return nil, p.errorf("expected )")
+		}
+		return q, nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *parser) parsePredicate() (*Query, error) {
+	if p.peek().kind != tokenIdent {
+		return nil, p.errorf("expected a field name")
+	}
+	c := Where(p.advance().text)
+
+	switch {
+	case p.keyword("in"):
+		if !p.symbol("(") {
+			return nil, p.errorf("expected (")
+		}
+		var values []interface{}
+		for {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			if p.symbol(")") {
+				break
+			}
+			if !p.symbol(",") {
+				return nil, p.errorf("expected , or )")
+			}
+		}
+		return c.In(values...), nil
+	case p.keyword("between"):
+		lo, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("and") {
+			return nil, p.errorf("expected and")
+		}
+		hi, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		return c.Between(lo, hi), nil
+	case p.keyword("like"):
+		t := p.peek()
+		if t.kind != tokenString || !strings.HasSuffix(t.text, "%") || strings.Count(t.text, "%") != 1 {
+			return nil, p.errorf("expected a string with a trailing %% wildcard")
+		}
+		p.advance()
+		return c.HasPrefix(strings.TrimSuffix(t.text, "%")), nil
+	}
+
+	t := p.peek()
+	if t.kind != tokenSymbol {
+		return nil, p.errorf("expected a comparison operator")
+	}
+	p.advance()
+	v, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	switch t.text {
+	case "=", "==":
+		return c.Eq(v), nil
+	case "!=", "<>":
+		return Not(c.Eq(v)), nil
+	case "<":
+		return c.Lt(v), nil
+	case "<=":
+		return c.Le(v), nil
+	case ">":
+		return c.Gt(v), nil
+	case ">=":
+		return c.Ge(v), nil
+	}
+	return nil, fmt.Errorf("unexpected operator %q at position %d: %w", t.text, t.pos, os.ErrInvalid)
+}
+
+// parseValue returns a string, bool, int64, uint64, float64 or time.Time
+// value for the next token.
+func (p *parser) parseValue() (interface{}, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenString:
+		p.advance()
+		return t.text, nil
+	case t.kind == tokenNumber:
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			p.advance()
+			return n, nil
+		}
+		// Integers beyond the int64 range are only valid for unsigned fields.
+		if n, err := strconv.ParseUint(t.text, 10, 64); err == nil {
+			p.advance()
+			return n, nil
+		}
+		if f, err := strconv.ParseFloat(t.text, 64); err == nil {
+			p.advance()
+			return f, nil
+		}
+		return nil, p.errorf("invalid number")
+	case t.kind == tokenIdent && strings.EqualFold(t.text, "true"):
+		p.advance()
+		return true, nil
+	case t.kind == tokenIdent && strings.EqualFold(t.text, "false"):
+		p.advance()
+		return false, nil
+	case t.kind == tokenIdent && strings.EqualFold(t.text, "time"):
+		p.advance()
+		s := p.peek()
+		if s.kind != tokenString {
+			return nil, p.errorf("expected a quoted RFC 3339 time")
+		}
+		v, err := time.Parse(time.RFC3339Nano, s.text)
+		if err != nil {
+			return nil, p.errorf("invalid RFC 3339 time")
+		}
+		p.advance()
+		return v, nil
+	}
+	return nil, p.errorf("expected a value")
+}
+
+// isDigit returns true for the ASCII digits, which are the only digits in
+// the numbers.
+func isDigit(c rune) bool {
+	return c >= '0' && c <= '9'
+}
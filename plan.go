@@ -0,0 +1,163 @@
+package kodb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bvkgo/kodb/internal"
+)
+
+// MaxEstimate is the maximum number of index keys counted to estimate the
+// number of objects referred from an index key range. Ranges with more index
+// keys are estimated to have MaxEstimate keys.
+const MaxEstimate = 1000
+
+// Plan describes how a query is answered. Plans are returned by the Explain
+// method of the iterators, with the number of keys examined by the query so
+// far, which can be used to debug slow queries.
+type Plan struct {
+	// Index holds the name of the index scanned for the candidate objects.
+	// Empty index name indicates that all objects in the database are scanned.
+	Index string
+
+	// Ranges holds the index key ranges scanned for the candidate objects.
+	Ranges [][2]string
+
+	// Estimate holds the estimated number of index keys in the scanned ranges.
+	// Estimate is -1 when the index was not chosen by the estimates, i.e.,
+	// when there was no choice to make or when the query is resumed with a
+	// cursor.
+	Estimate int
+
+	// Candidates holds the indexes that were considered for the query,
+	// including the chosen index.
+	Candidates []*PlanIndex
+
+	// Filters holds the number of index key ranges that are checked against
+	// the index keys of the candidate objects.
+	Filters int
+
+	// KeysExamined holds the number of index keys (or object keys for object
+	// scans) read by the query. ObjectsLoaded holds the number of candidate
+	// objects read from the database and ObjectsReturned holds the number of
+	// objects returned through the iterator.
+	KeysExamined    int
+	ObjectsLoaded   int
+	ObjectsReturned int
+}
+
+// PlanIndex holds an index considered for a query.
+type PlanIndex struct {
+	Index    string
+	Ranges   [][2]string
+	Estimate int
+}
+
+func (p *Plan) String() string {
+	var sb strings.Builder
+	if len(p.Index) == 0 {
+		sb.WriteString("object scan")
+	} else {
+		fmt.Fprintf(&sb, "index scan on %s (%d ranges, estimate %d)", p.Index, len(p.Ranges), p.Estimate)
+	}
+	if p.Filters > 0 {
+		fmt.Fprintf(&sb, " with %d filters", p.Filters)
+	}
+	fmt.Fprintf(&sb, ": examined %d keys, loaded %d objects, returned %d objects", p.KeysExamined, p.ObjectsLoaded, p.ObjectsReturned)
+	return sb.String()
+}
+
+// newPlanIndex returns the plan entry for index key ranges. Ranges from more
+// than one index are named with the comma separated index names.
+func newPlanIndex(ranges [][2]string, estimate int) *PlanIndex {
+	var names []string
+	for _, r := range ranges {
+		// Range boundaries are not index keys, but they share the type name and
+		// index name prefix with the index keys.
+		name, err := internal.IndexKey(r[0]).GetFieldName()
+		if err != nil {
+			continue
+		}
+		if n := len(names); n == 0 || names[n-1] != name {
+			names = append(names, name)
+		}
+	}
+	return &PlanIndex{Index: strings.Join(names, ","), Ranges: ranges, Estimate: estimate}
+}
+
+// estimateRange returns the number of index keys in a range, up to the
+// MaxEstimate keys.
+func (t *Tx) estimateRange(ctx context.Context, r [2]string) (int, error) {
+	c, err := t.newIndexCursor(ctx, r, false)
+	if err != nil {
+		return 0, err
+	}
+	for !c.done && c.n < MaxEstimate {
+		if err := c.next(ctx); err != nil {
+			return 0, err
+		}
+	}
+	return c.n, nil
+}
+
+// estimateRanges returns the total number of index keys in the ranges, up to
+// the MaxEstimate keys.
+func (t *Tx) estimateRanges(ctx context.Context, ranges [][2]string) (int, error) {
+	total := 0
+	for _, r := range ranges {
+		n, err := t.estimateRange(ctx, r)
+		if err != nil {
+			return 0, err
+		}
+		if total += n; total >= MaxEstimate {
+			return MaxEstimate, nil
+		}
+	}
+	return total, nil
+}
+
+// inRanges returns true if the key belongs to any of the ranges.
+func inRanges(key string, ranges [][2]string) bool {
+	for _, r := range ranges {
+		if key >= r[0] && key < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// chooseIndex picks the candidate with the smallest estimate. When after is
+// non-empty, candidate that holds the resume point is picked, so that the
+// query is resumed with the same index. Returns -1 if there are no
+// candidates.
+func (t *Tx) chooseIndex(ctx context.Context, candidates []*PlanIndex, after string) (int, error) {
+	if len(after) > 0 {
+		for i, c := range candidates {
+			if inRanges(after, c.Ranges) {
+				for _, c := range candidates {
+					c.Estimate = -1
+				}
+				return i, nil
+			}
+		}
+	}
+	if len(candidates) < 2 {
+		for _, c := range candidates {
+			c.Estimate = -1
+		}
+		return len(candidates) - 1, nil
+	}
+	best := -1
+	for i, c := range candidates {
+		n, err := t.estimateRanges(ctx, c.Ranges)
+		if err != nil {
+			return -1, err
+		}
+		c.Estimate = n
+		if best < 0 || n < candidates[best].Estimate {
+			best = i
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,594 @@
+package kodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/bvkgo/kodb/internal"
+	"github.com/bvkgo/kv"
+)
+
+type queryOp int
+
+const (
+	opAnd queryOp = iota
+	opOr
+	opNot
+	opIn
+	opRange
+	opPrefix
+)
+
+// Query is a predicate over the fields of the objects, which is built with
+// the Where function and combined with the And, Or and Not functions (or
+// methods). For example:
+//
+//	q := kodb.Where("Status").In("open", "pending").And(kodb.Where("Age").Gt(18))
+//
+// Predicates on the indexed fields are answered with index scans and others,
+// including the predicates matching the zero values that are not indexed, are
+// checked against the objects.
+type Query struct {
+	op    queryOp
+	field string
+
+	// values holds the values for the In predicates.
+	values []interface{}
+
+	// lo and hi hold the boundaries for the range predicates, where a nil
+	// value leaves the range unbounded on that side. loOpen and hiOpen when
+	// true, exclude the boundary values from the range.
+	lo, hi         interface{}
+	loOpen, hiOpen bool
+
+	prefix string
+
+	// subs holds the sub-queries for the And, Or and Not queries.
+	subs []*Query
+}
+
+// Condition builds the predicates for a field.
+type Condition struct {
+	field string
+}
+
+// Where returns a predicate builder for a field, which is named by it's index
+// name or the dotted path of the struct field for the fields that are not
+// indexed.
+func Where(field string) *Condition {
+	return &Condition{field: field}
+}
+
+// Eq matches the objects with the field value equal to v. Multi-valued
+// fields match when any of their values is equal to v.
+func (c *Condition) Eq(v interface{}) *Query {
+	return c.In(v)
+}
+
+// In matches the objects with the field value equal to any of the values.
+func (c *Condition) In(vs ...interface{}) *Query {
+	return &Query{op: opIn, field: c.field, values: vs}
+}
+
+// Gt matches the objects with the field value greater than v.
+func (c *Condition) Gt(v interface{}) *Query {
+	return &Query{op: opRange, field: c.field, lo: v, loOpen: true}
+}
+
+// Ge matches the objects with the field value greater than or equal to v.
+func (c *Condition) Ge(v interface{}) *Query {
+	return &Query{op: opRange, field: c.field, lo: v}
+}
+
+// Lt matches the objects with the field value less than v.
+func (c *Condition) Lt(v interface{}) *Query {
+	return &Query{op: opRange, field: c.field, hi: v, hiOpen: true}
+}
+
+// Le matches the objects with the field value less than or equal to v.
+func (c *Condition) Le(v interface{}) *Query {
+	return &Query{op: opRange, field: c.field, hi: v}
+}
+
+// Between matches the objects with the field value in the closed interval
+// [lo, hi].
+func (c *Condition) Between(lo, hi interface{}) *Query {
+	return &Query{op: opRange, field: c.field, lo: lo, hi: hi}
+}
+
+// HasPrefix matches the objects with the string field value beginning with
+// the prefix.
+func (c *Condition) HasPrefix(prefix string) *Query {
+	return &Query{op: opPrefix, field: c.field, prefix: prefix}
+}
+
+// And matches the objects matched by all of the queries.
+func And(qs ...*Query) *Query {
+	return &Query{op: opAnd, subs: qs}
+}
+
+// Or matches the objects matched by any of the queries.
+func Or(qs ...*Query) *Query {
+	return &Query{op: opOr, subs: qs}
+}
+
+// Not matches the objects that are not matched by the query.
+func Not(q *Query) *Query {
+	return &Query{op: opNot, subs: []*Query{q}}
+}
+
+// And matches the objects matched by the query and all of the other queries.
+func (q *Query) And(qs ...*Query) *Query {
+	return And(append([]*Query{q}, qs...)...)
+}
+
+// Or matches the objects matched by the query or any of the other queries.
+func (q *Query) Or(qs ...*Query) *Query {
+	return Or(append([]*Query{q}, qs...)...)
+}
+
+// Not matches the objects matched by the query, but not by any of the other
+// queries.
+func (q *Query) Not(qs ...*Query) *Query {
+	subs := []*Query{q}
+	for _, x := range qs {
+		subs = append(subs, Not(x))
+	}
+	return And(subs...)
+}
+
+// predicate holds a query compiled for a data type.
+type predicate struct {
+	op   queryOp
+	subs []*predicate
+
+	// field and ranges hold the field and the index keyspace ranges matched
+	// by the field predicates.
+	field  *internal.QueryField
+	ranges [][2]string
+}
+
+// compileQuery resolves the fields of a query and converts the field values
+// into their index keyspace ranges.
+func compileQuery(datatype *internal.DataType, q *Query) (*predicate, error) {
+	if q == nil {
+		return nil, fmt.Errorf("query cannot be nil: %w", os.ErrInvalid)
+	}
+	p := &predicate{op: q.op}
+	switch q.op {
+	case opAnd, opOr, opNot:
+		if len(q.subs) == 0 {
+			return nil, fmt.Errorf("query must have at least one sub-query: %w", os.ErrInvalid)
+		}
+		for _, sub := range q.subs {
+			x, err := compileQuery(datatype, sub)
+			if err != nil {
+				return nil, err
+			}
+			p.subs = append(p.subs, x)
+		}
+		return p, nil
+	}
+
+	field, err := datatype.QueryField(q.field)
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := fieldRanges(field, q)
+	if err != nil {
+		return nil, err
+	}
+	// Zero values of the fields indexed without the zero option have no index
+	// keys, so predicates that match the zero values are checked with the
+	// field values of the objects, like the fields without an index.
+	if zf := field.ZeroField(); zf != nil {
+		zranges, err := fieldRanges(zf, q)
+		if err != nil {
+			return nil, err
+		}
+		zero, err := zf.HasZero(zranges)
+		if err != nil {
+			return nil, err
+		}
+		if zero {
+			field, ranges = zf, zranges
+		}
+	}
+	p.field = field
+	p.ranges = mergeRanges(ranges)
+	return p, nil
+}
+
+// fieldRanges returns the index keyspace ranges for the values matched by a
+// field predicate.
+func fieldRanges(field *internal.QueryField, q *Query) ([][2]string, error) {
+	var ranges [][2]string
+	switch q.op {
+	case opIn:
+		if len(q.values) == 0 {
+			return nil, fmt.Errorf("query on field %s must have at least one value: %w", q.field, os.ErrInvalid)
+		}
+		for _, v := range q.values {
+			if v == nil {
+				return nil, fmt.Errorf("nil value is not valid for field %s: %w", q.field, os.ErrInvalid)
+			}
+			r, err := field.ValueRange(v, v, false, false)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, r)
+		}
+	case opRange:
+		r, err := field.ValueRange(q.lo, q.hi, q.loOpen, q.hiOpen)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	case opPrefix:
+		r, err := field.PrefixRange(q.prefix)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	default:
+		return nil, fmt.Errorf("unknown query operation %d: %w", q.op, os.ErrInvalid)
+	}
+	return ranges, nil
+}
+
+// mergeRanges sorts the ranges and merges the overlapping ranges, so that no
+// index key belongs to more than one range.
+func mergeRanges(ranges [][2]string) [][2]string {
+	var rs [][2]string
+	for _, r := range ranges {
+		// Empty ranges refer to no index keys.
+		if r[0] < r[1] {
+			rs = append(rs, r)
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i][0] < rs[j][0]
+	})
+	var merged [][2]string
+	for _, r := range rs {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1] {
+			if r[1] > merged[n-1][1] {
+				merged[n-1][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// planQuery returns the candidate index key ranges that refer to all objects
+// matched by the predicate along with the position of the chosen candidate.
+// Returns -1 if the predicate cannot be answered with index scans.
+func (t *Tx) planQuery(ctx context.Context, p *predicate, after string) ([]*PlanIndex, int, error) {
+	switch p.op {
+	case opAnd:
+		// Any of the sub-queries can find all objects.
+		var candidates []*PlanIndex
+		for _, sub := range p.subs {
+			cs, best, err := t.planQuery(ctx, sub, after)
+			if err != nil {
+				return nil, -1, err
+			}
+			if best >= 0 {
+				candidates = append(candidates, cs[best])
+			}
+		}
+		best, err := t.chooseIndex(ctx, candidates, after)
+		if err != nil {
+			return nil, -1, err
+		}
+		return candidates, best, nil
+	case opOr:
+		// All of the sub-queries are necessary to find all objects.
+		var ranges [][2]string
+		for _, sub := range p.subs {
+			cs, best, err := t.planQuery(ctx, sub, after)
+			if err != nil || best < 0 {
+				return nil, -1, err
+			}
+			ranges = append(ranges, cs[best].Ranges...)
+		}
+		return []*PlanIndex{newPlanIndex(mergeRanges(ranges), -1)}, 0, nil
+	case opNot:
+		return nil, -1, nil
+	}
+	if !p.field.Indexed() {
+		return nil, -1, nil
+	}
+	return []*PlanIndex{newPlanIndex(p.ranges, -1)}, 0, nil
+}
+
+// queryObject holds an object loaded for the predicate checks, which is only
+// unmarshaled when a predicate on a field without an index is checked.
+type queryObject struct {
+	datatype *internal.DataType
+	value    *internal.Value
+	ob       interface{}
+}
+
+func (q *queryObject) object() (interface{}, error) {
+	if q.ob == nil {
+		ob := q.datatype.New()
+		if err := q.datatype.Unmarshal(q.value.Data, ob); err != nil {
+			return nil, err
+		}
+		q.ob = ob
+	}
+	return q.ob, nil
+}
+
+// match returns true if the object satisfies the predicate.
+func (p *predicate) match(q *queryObject) (bool, error) {
+	switch p.op {
+	case opAnd:
+		for _, sub := range p.subs {
+			if ok, err := sub.match(q); err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case opOr:
+		for _, sub := range p.subs {
+			if ok, err := sub.match(q); err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case opNot:
+		ok, err := p.subs[0].match(q)
+		return !ok, err
+	}
+
+	var ob interface{}
+	if !p.field.Indexed() {
+		x, err := q.object()
+		if err != nil {
+			return false, err
+		}
+		ob = x
+	}
+	iks, err := p.field.IndexKeys(q.value, ob)
+	if err != nil {
+		return false, err
+	}
+	return findInRanges(iks, p.ranges) != "", nil
+}
+
+// findInRanges returns the smallest index key that belongs to any of the
+// ranges. Returns empty string if no index key is in the ranges.
+func findInRanges(iks []internal.IndexKey, ranges [][2]string) internal.IndexKey {
+	// Index keys of the objects are kept in the ascending order.
+	for _, ik := range iks {
+		for _, r := range ranges {
+			if k := ik.String(); k >= r[0] && k < r[1] {
+				return ik
+			}
+		}
+	}
+	return ""
+}
+
+// queryStream returns the objects matched by a query. Objects are found by
+// scanning the driving ranges of the query, one after the other, or by
+// scanning all objects when the query has no driving ranges.
+type queryStream struct {
+	tx       *Tx
+	datatype *internal.DataType
+	pred     *predicate
+
+	// driving holds all driving ranges and ranges holds the driving ranges
+	// that are not yet scanned. scan is true if all objects must be scanned.
+	// desc is true if the driving ranges are scanned in the descending order.
+	driving [][2]string
+	ranges  [][2]string
+	scan    bool
+	desc    bool
+
+	after  string
+	cursor *indexCursor
+	it     kv.Iterator
+
+	plan     *Plan
+	examined int
+}
+
+func (t *Tx) newQueryStream(ctx context.Context, datatype *internal.DataType, q *Query, after string) (*queryStream, error) {
+	s := &queryStream{tx: t, datatype: datatype, after: after, plan: &Plan{Estimate: -1}}
+	var candidates []*PlanIndex
+	best := -1
+	// Nil query matches all objects of the data type.
+	if q != nil {
+		pred, err := compileQuery(datatype, q)
+		if err != nil {
+			return nil, err
+		}
+		if candidates, best, err = t.planQuery(ctx, pred, after); err != nil {
+			return nil, err
+		}
+		s.pred = pred
+	}
+	s.plan.Candidates = candidates
+	if best < 0 {
+		if len(after) > 0 {
+			if _, err := internal.ParseObjectKey(after); err != nil {
+				return nil, err
+			}
+		}
+		s.scan = true
+		return s, nil
+	}
+	ranges := candidates[best].Ranges
+	s.plan.Index = candidates[best].Index
+	s.plan.Ranges = ranges
+	s.plan.Estimate = candidates[best].Estimate
+	s.driving = ranges
+	if len(after) > 0 {
+		if _, err := internal.ParseIndexKey(after); err != nil {
+			return nil, err
+		}
+		// Ranges are sorted and disjoint, so the ranges that end before the
+		// resume point are already scanned.
+		for len(ranges) > 0 && ranges[0][1] <= after {
+			ranges = ranges[1:]
+		}
+	}
+	s.ranges = ranges
+	return s, nil
+}
+
+// newOrderedStream returns the objects matched by a query in the order of
+// their values for an indexed field, by scanning all index keys of the field.
+// Objects without a value for the field are not in the index, so they are not
+// returned. Objects with multiple values for the field are ordered by their
+// smallest values.
+func (t *Tx) newOrderedStream(ctx context.Context, datatype *internal.DataType, q *Query, field string, desc bool) (*queryStream, error) {
+	r, err := datatype.IndexValueRange(field, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	s := &queryStream{tx: t, datatype: datatype, desc: desc}
+	if q != nil {
+		if s.pred, err = compileQuery(datatype, q); err != nil {
+			return nil, err
+		}
+	}
+	s.driving = [][2]string{r}
+	s.ranges = s.driving
+	s.plan = &Plan{Index: field, Ranges: s.driving, Estimate: -1}
+	return s, nil
+}
+
+func (s *queryStream) next(ctx context.Context) (*iterRef, error) {
+	if s.scan {
+		return s.nextObject(ctx)
+	}
+	for {
+		if s.cursor == nil {
+			if len(s.ranges) == 0 {
+				return nil, os.ErrNotExist
+			}
+			r := s.ranges[0]
+			s.ranges = s.ranges[1:]
+			if next := s.after + "\x00"; len(s.after) > 0 && next > r[0] {
+				r[0] = next
+			}
+			c, err := s.tx.newIndexCursor(ctx, r, s.desc)
+			if err != nil {
+				return nil, err
+			}
+			s.cursor = c
+		}
+		if s.cursor.done {
+			s.cursor = nil
+			continue
+		}
+		c := s.cursor
+		r := &iterRef{okey: c.okey, refs: []internal.IndexKey{c.ik}, proj: c.proj, pos: c.ik.String()}
+		if err := c.next(ctx); err != nil {
+			return nil, err
+		}
+		s.examined++
+		return r, nil
+	}
+}
+
+// nextObject returns the next object of the query's data type from the
+// object keyspace.
+func (s *queryStream) nextObject(ctx context.Context) (*iterRef, error) {
+	if s.it == nil {
+		r := internal.KeyspaceRange(internal.ObjectKeyspace)
+		if next := s.after + "\x00"; len(s.after) > 0 && next > r[0] {
+			r[0] = next
+		}
+		it, err := s.tx.ascend(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		if it == nil {
+			return nil, os.ErrNotExist
+		}
+		s.it = it
+	}
+	for {
+		k, value, err := s.it.GetNext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.examined++
+		v, err := internal.ParseValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("key %s has invalid value: %w", k, ErrIndexInconsistent)
+		}
+		if v.Type != s.datatype.Name() {
+			continue
+		}
+		okey, err := internal.ParseObjectKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &iterRef{okey: okey, proj: v.Projection, pos: k}, nil
+	}
+}
+
+// match returns true if the object satisfies the query. Objects referred from
+// multiple driving ranges are only matched at their smallest index key in the
+// ranges.
+func (s *queryStream) match(v *internal.Value, r *iterRef) (bool, error) {
+	if v.Type != s.datatype.Name() {
+		return false, nil
+	}
+	if !s.scan {
+		if findInRanges(v.IndexKeys, s.driving) != r.refs[0] {
+			return false, nil
+		}
+	}
+	if s.pred == nil {
+		return true, nil
+	}
+	return s.pred.match(&queryObject{datatype: s.datatype, value: v})
+}
+
+func (s *queryStream) filtered() bool {
+	return true
+}
+
+func (s *queryStream) explain() *Plan {
+	p := *s.plan
+	p.KeysExamined = s.examined
+	return &p
+}
+
+// FindByQuery returns zero or more objects matching the query through the
+// iterator. Input object is only used to identify the data type and a nil
+// query matches all objects of the data type. Objects are returned in the
+// order of the index keys scanned for the query, or in the order of the
+// object keys when the query has no usable index.
+func (t *Tx) FindByQuery(ctx context.Context, sample interface{}, q *Query, iterator Iterator) error {
+	iter, ok := iterator.(*Iter)
+	if !ok {
+		return os.ErrInvalid
+	}
+
+	datatype, err := internal.GetDataType(sample)
+	if err != nil {
+		return err
+	}
+	stream, err := t.newQueryStream(ctx, datatype, q, iter.after)
+	if err != nil {
+		if errors.Is(err, internal.ErrInvalidKey) {
+			return fmt.Errorf("invalid cursor for the query: %w", err)
+		}
+		return err
+	}
+	iter.reset(t, stream)
+	return nil
+}
@@ -11,7 +11,9 @@ func RegisterDataType(name string, otype reflect.Type) error {
 	return internal.Register(name, reflect.New(otype).Interface())
 }
 
-// RegisterIndexStringer adds a string converter for an index field type.
+// RegisterIndexStringer adds a string converter for an index field type. It
+// overrides all other conversions for the type, including the
+// encoding.TextMarshaler and fmt.Stringer interfaces.
 func RegisterIndexStringer(ftype reflect.Type, stringer func(reflect.Value) (string, error)) error {
 	return internal.RegisterIndexFieldType(ftype, stringer)
 }
@@ -0,0 +1,188 @@
+package kodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bvkgo/kodb/internal"
+)
+
+// DefaultBatchSize is the default number of keys scanned in a single
+// transaction by the Reindex and Check apis.
+const DefaultBatchSize = 100
+
+// ReindexOptions holds optional parameters for the Reindex api.
+type ReindexOptions struct {
+	// BatchSize limits the number of objects scanned in a single transaction.
+	// DefaultBatchSize is used when it is zero.
+	BatchSize int
+
+	// StartAfter when non-empty, skips all objects with user keys up to and
+	// including the given key. It can be used to resume an interrupted
+	// Reindex from the last checkpoint.
+	StartAfter string
+
+	// Checkpoint if non-nil, is called after every committed batch with the
+	// user key of the last object scanned in the batch.
+	Checkpoint func(ctx context.Context, lastKey string) error
+}
+
+// Reindex recomputes the index keys for all stored objects of a registered
+// data type, so that indexes added or modified after the objects were stored
+// can be used to find them. Missing index keys are added and obsolete index
+// keys are removed for every object.
+//
+// Objects are scanned in the ascending order of their keys, in separate
+// transactions of bounded size. Reindex can be resumed after an interruption
+// by passing the last checkpointed key in the StartAfter option.
+func (d *DB) Reindex(ctx context.Context, typeName string, opts *ReindexOptions) error {
+	datatype, err := internal.GetDataTypeByName(typeName)
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = new(ReindexOptions)
+	}
+
+	r := internal.KeyspaceRange(internal.ObjectKeyspace)
+	if len(opts.StartAfter) > 0 {
+		okey, err := internal.NewObjectKey(opts.StartAfter)
+		if err != nil {
+			return err
+		}
+		// Smallest key that is larger than the start key.
+		r[0] = okey.String() + "\x00"
+	}
+
+	reindex := func(ctx context.Context, tx *Tx, keys, values []string) error {
+		for i, s := range values {
+			v, err := internal.ParseValue(s)
+			if err != nil {
+				return fmt.Errorf("could not parse object at %s: %w", keys[i], err)
+			}
+			if v.Type != datatype.Name() {
+				continue
+			}
+			if err := tx.reindexObject(ctx, datatype, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var checkpoint func(context.Context, string) error
+	if opts.Checkpoint != nil {
+		checkpoint = func(ctx context.Context, last string) error {
+			okey, err := internal.ParseObjectKey(last)
+			if err != nil {
+				return err
+			}
+			return opts.Checkpoint(ctx, okey.UserKey())
+		}
+	}
+	return d.scanBatches(ctx, r, opts.BatchSize, reindex, checkpoint)
+}
+
+// scanBatches walks all key-value pairs in the [begin, end) range in batches.
+// Every batch is processed by the scan function in a separate transaction,
+// which is committed if the scan function succeeds. Done function, if
+// non-nil, is called with the last key of every committed batch.
+func (d *DB) scanBatches(ctx context.Context, r [2]string, batchSize int, scan func(ctx context.Context, tx *Tx, keys, values []string) error, done func(ctx context.Context, last string) error) error {
+	if batchSize == 0 {
+		batchSize = DefaultBatchSize
+	}
+	if batchSize < 0 {
+		return fmt.Errorf("batch size cannot be negative: %w", os.ErrInvalid)
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		last, err := d.scanBatch(ctx, r, batchSize, scan)
+		if err != nil {
+			return err
+		}
+		if len(last) == 0 {
+			return nil
+		}
+		if done != nil {
+			if err := done(ctx, last); err != nil {
+				return err
+			}
+		}
+		// Smallest key that is larger than the last key.
+		r[0] = last + "\x00"
+	}
+}
+
+// scanBatch processes the next batch of key-value pairs in the range in a
+// single transaction. Returns the last key of the batch or empty string when
+// the range is empty.
+func (d *DB) scanBatch(ctx context.Context, r [2]string, batchSize int, scan func(ctx context.Context, tx *Tx, keys, values []string) error) (last string, status error) {
+	tx, err := d.NewTx(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if status != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	keys, values, err := tx.scanRange(ctx, r, batchSize)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return "", tx.Rollback(ctx)
+	}
+	if err := scan(ctx, tx, keys, values); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return "", err
+	}
+	return keys[len(keys)-1], nil
+}
+
+// reindexObject recomputes the index keys for a stored object value. Unlike
+// Store, all current index keys are written irrespective of the stored index
+// keys, so that missing index keys are also restored.
+func (t *Tx) reindexObject(ctx context.Context, datatype *internal.DataType, old *internal.Value) error {
+	ob := datatype.New()
+	if err := datatype.Unmarshal(old.Data, ob); err != nil {
+		return err
+	}
+	cur, err := internal.NewValue(old.ObjectKey, ob, datatype)
+	if err != nil {
+		return err
+	}
+	deletions, additions := internal.DiffIndexKeys(old.IndexKeys, cur.IndexKeys)
+	for _, i := range additions {
+		if err := t.checkUnique(ctx, datatype, cur.ObjectKey, i); err != nil {
+			return err
+		}
+	}
+	for _, i := range cur.IndexKeys {
+		if err := t.tx.Set(ctx, i.String(), cur.Projection); err != nil {
+			return err
+		}
+	}
+	value, err := cur.Encode()
+	if err != nil {
+		return err
+	}
+	if err := t.tx.Set(ctx, cur.ObjectKey.String(), value); err != nil {
+		return err
+	}
+	for _, d := range deletions {
+		if err := t.tx.Delete(ctx, d.String()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := t.deleteUnique(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
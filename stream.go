@@ -0,0 +1,276 @@
+package kodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bvkgo/kodb/internal"
+	"github.com/bvkgo/kv"
+)
+
+// objectStream produces the object references for the iterators.
+type objectStream interface {
+	// next returns the next object reference in the stream. Returns
+	// os.ErrNotExist when there are no more objects.
+	next(ctx context.Context) (*iterRef, error)
+
+	// match returns true if the object loaded for a reference from the stream
+	// belongs to the results.
+	match(v *internal.Value, r *iterRef) (bool, error)
+
+	// filtered returns true if the objects must be loaded to decide if they
+	// belong to the results.
+	filtered() bool
+
+	// explain returns the plan for the stream with the number of keys
+	// examined so far.
+	explain() *Plan
+}
+
+// indexCursor walks the index keys in a range, one index key at a time.
+type indexCursor struct {
+	it kv.Iterator
+
+	// ik, okey and proj hold the current index key, it's object key and
+	// projection. done is true when the range is exhausted.
+	ik   internal.IndexKey
+	okey internal.ObjectKey
+	proj string
+	done bool
+
+	// n holds the number of index keys read from the range.
+	n int
+}
+
+// newIndexCursor returns a cursor over the index keys in a range, which walks
+// the range in the descending order when desc is true.
+func (t *Tx) newIndexCursor(ctx context.Context, r [2]string, desc bool) (*indexCursor, error) {
+	c := &indexCursor{done: true}
+	scan := t.ascend
+	if desc {
+		scan = t.descend
+	}
+	it, err := scan(ctx, r)
+	if err != nil || it == nil {
+		return c, err
+	}
+	c.it, c.done = it, false
+	if err := c.next(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// next advances the cursor to the next index key in the range.
+func (c *indexCursor) next(ctx context.Context) error {
+	if c.done {
+		return nil
+	}
+	k, v, err := c.it.GetNext(ctx)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		c.done = true
+		return nil
+	}
+	c.n++
+	ik, err := internal.ParseIndexKey(k)
+	if err != nil {
+		return fmt.Errorf("unexpected index key failure: %w", err)
+	}
+	okey, err := ik.GetObjectKey()
+	if err != nil {
+		return fmt.Errorf("index key with invalid object key: %w", err)
+	}
+	c.ik, c.okey, c.proj = ik, okey, v
+	return nil
+}
+
+// indexStream returns the objects referred from all of the index key ranges
+// without collecting all index keys in memory.
+//
+// Objects are found by scanning the range with the fewest index keys and the
+// remaining ranges are checked for each object. Exact ranges are preferred to
+// drive the stream, because they are ordered by the object keys and refer to
+// an object at most once. Remaining exact ranges are checked by looking up
+// the object's index key in the range, which doesn't require loading the
+// object, and other ranges are checked against the index keys of the objects
+// when they are loaded.
+//
+// Objects are returned in the order of the index keys in the driving range,
+// so the index key of the last returned object is enough to resume the
+// stream from the next object.
+type indexStream struct {
+	tx      *Tx
+	cursor  *indexCursor
+	probes  [][2]string
+	filters [][2]string
+
+	// distinct when true, returns the objects only at their smallest index key
+	// in the driving range, which is necessary for the multi-valued fields.
+	distinct bool
+	driver   [2]string
+
+	// plan holds the plan for the stream and probed holds the number of index
+	// keys looked up in the exact ranges.
+	plan   *Plan
+	probed int
+}
+
+// newIndexStream creates a stream for the objects referred from all the
+// ranges. When after is non-empty, stream is resumed from the object after
+// the index key, which must be from the driving range of an earlier stream
+// for the same ranges.
+func (t *Tx) newIndexStream(ctx context.Context, ranges []internal.QueryRange, after internal.IndexKey, distinct bool) (*indexStream, error) {
+	s := &indexStream{tx: t, distinct: distinct, plan: new(Plan)}
+	exact := false
+	for _, r := range ranges {
+		exact = exact || r.Exact
+	}
+	var candidates []*PlanIndex
+	var positions []int
+	for i, r := range ranges {
+		if r.Exact == exact {
+			candidates = append(candidates, newPlanIndex([][2]string{r.Range}, 0))
+			positions = append(positions, i)
+		}
+	}
+	// Any exact range can resume from the object key of an index key from
+	// another exact range.
+	resume := after.String()
+	if exact {
+		resume = ""
+	}
+	best, err := t.chooseIndex(ctx, candidates, resume)
+	if err != nil {
+		return nil, err
+	}
+	s.plan.Candidates = candidates
+	s.plan.Estimate = -1
+	if best < 0 {
+		return s, nil
+	}
+	for i, r := range ranges {
+		if i == positions[best] {
+			continue
+		}
+		if r.Exact {
+			s.probes = append(s.probes, r.Range)
+		} else {
+			s.filters = append(s.filters, r.Range)
+		}
+	}
+	s.driver = ranges[positions[best]].Range
+	s.plan.Index = candidates[best].Index
+	s.plan.Ranges = candidates[best].Ranges
+	s.plan.Estimate = candidates[best].Estimate
+	s.plan.Filters = len(s.probes) + len(s.filters)
+
+	r := s.driver
+	if len(after) > 0 {
+		next := after.String() + "\x00"
+		if exact {
+			// Exact ranges hold the index keys of a single field value, so the
+			// resume point is the same object key in every exact range.
+			okey, err := after.GetObjectKey()
+			if err != nil {
+				return nil, err
+			}
+			next = internal.ExactRangeKey(r, okey).String() + "\x00"
+		}
+		if next > r[0] {
+			r[0] = next
+		}
+	}
+	c, err := t.newIndexCursor(ctx, r, false)
+	if err != nil {
+		return nil, err
+	}
+	s.cursor = c
+	return s, nil
+}
+
+// next returns the next object key referred from the driving range and all
+// exact ranges, along with the referring index keys and the projection.
+// Returns os.ErrNotExist when there are no more objects.
+func (s *indexStream) next(ctx context.Context) (*iterRef, error) {
+	for s.cursor != nil && !s.cursor.done {
+		c := s.cursor
+		r := &iterRef{okey: c.okey, refs: []internal.IndexKey{c.ik}, proj: c.proj, pos: c.ik.String()}
+		if err := c.next(ctx); err != nil {
+			return nil, err
+		}
+		found, err := s.probe(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return r, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// probe looks up the index keys of the object in the exact ranges and adds
+// them to the references. Returns false if any index key is not found.
+func (s *indexStream) probe(ctx context.Context, r *iterRef) (bool, error) {
+	for _, p := range s.probes {
+		ik := internal.ExactRangeKey(p, r.okey)
+		s.probed++
+		if _, err := s.tx.tx.Get(ctx, ik.String()); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return false, nil
+			}
+			return false, err
+		}
+		r.refs = append(r.refs, ik)
+	}
+	return true, nil
+}
+
+// match returns true if the object has an index key in every filter range.
+// For distinct streams, the referring index key from the driving range must
+// also be the smallest index key of the object in that range.
+func (s *indexStream) match(v *internal.Value, r *iterRef) (bool, error) {
+	if s.distinct && len(r.refs) > 0 {
+		d := s.driver
+		// Index keys of the objects are kept in the ascending order.
+		for _, ik := range v.IndexKeys {
+			if k := ik.String(); k >= d[0] && k < d[1] {
+				if ik != r.refs[0] {
+					return false, nil
+				}
+				break
+			}
+		}
+	}
+	for _, f := range s.filters {
+		found := false
+		for _, ik := range v.IndexKeys {
+			if k := ik.String(); k >= f[0] && k < f[1] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (s *indexStream) filtered() bool {
+	return s.distinct || len(s.filters) > 0
+}
+
+func (s *indexStream) explain() *Plan {
+	p := *s.plan
+	p.KeysExamined = s.probed
+	if s.cursor != nil {
+		p.KeysExamined += s.cursor.n
+	}
+	return &p
+}